@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// autoSampleSize is the number of bytes at the beginning of the input
+// used to detect the delimiter.
+const autoSampleSize = 1 << 20
+
+// autoMinLen is the minimum length of lines considered delimiter lines,
+// so that emoticons and the like don't count.
+const autoMinLen = 3
+
+// delimiterGuess is the result of detecting the delimiter.
+type delimiterGuess struct {
+	char       rune
+	len        int     // minimum length of the delimiter lines
+	lines      int     // number of delimiter lines in the sample
+	confidence float64 // share of all candidate lines using char
+}
+
+// detectDelimiter guesses the delimiter char and length from the
+// beginning of the input. It returns a reader that still yields the whole
+// input. ok is false if there are no candidate lines at all.
+func detectDelimiter(r io.Reader) (io.Reader, delimiterGuess, bool, error) {
+	br := bufio.NewReaderSize(r, autoSampleSize)
+
+	sample, err := br.Peek(autoSampleSize)
+	if err != nil && err != io.EOF {
+		return br, delimiterGuess{}, false, err
+	}
+
+	lines := strings.Split(string(sample), "\n")
+	if err == nil {
+		// the last line is probably incomplete
+		lines = lines[:len(lines)-1]
+	}
+
+	guess, ok := guessDelimiter(lines)
+	return br, guess, ok, nil
+}
+
+// guessDelimiter looks for lines consisting of a single punctuation or
+// symbol char repeated at least autoMinLen times. The char used by most of
+// these lines wins, and the shortest of its lines determines the length.
+func guessDelimiter(lines []string) (delimiterGuess, bool) {
+	counts := make(map[rune]int)
+	minLens := make(map[rune]int)
+	total := 0
+
+	for _, line := range lines {
+		char, length := repeatedChar(strings.TrimRightFunc(line, unicode.IsSpace))
+		if length < autoMinLen {
+			continue
+		}
+
+		total++
+		counts[char]++
+		if minLens[char] == 0 || length < minLens[char] {
+			minLens[char] = length
+		}
+	}
+
+	if total == 0 {
+		return delimiterGuess{}, false
+	}
+
+	var best rune
+	for char, count := range counts {
+		if count > counts[best] || (count == counts[best] && char < best) {
+			best = char
+		}
+	}
+
+	return delimiterGuess{
+		char:       best,
+		len:        minLens[best],
+		lines:      counts[best],
+		confidence: float64(counts[best]) / float64(total),
+	}, true
+}
+
+// repeatedChar returns the char and length of s if s consists of a single
+// repeated punctuation or symbol char, or a length of 0 otherwise.
+func repeatedChar(s string) (rune, int) {
+	first, _ := utf8.DecodeRuneInString(s)
+	if !unicode.IsPunct(first) && !unicode.IsSymbol(first) {
+		return 0, 0
+	}
+
+	length := 0
+	for _, char := range s {
+		if char != first {
+			return 0, 0
+		}
+		length++
+	}
+
+	return first, length
+}
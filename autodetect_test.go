@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGuessDelimiter(t *testing.T) {
+	guess, ok := guessDelimiter([]string{
+		"~~~~~~~~",
+		"foo foo",
+		"---",
+		"bar",
+		"~~~~~~  ",
+		"  ==========",
+		":-)",
+		"~~",
+		"~~~~~~~~",
+		"baz",
+	})
+
+	if !ok {
+		t.Fatalf("expected a guess")
+	}
+
+	expected := delimiterGuess{char: '~', len: 6, lines: 3, confidence: 0.75}
+	if guess != expected {
+		t.Errorf("expected: %+v, actual: %+v.", expected, guess)
+	}
+
+	if _, ok := guessDelimiter([]string{"foo", "==", "bar"}); ok {
+		t.Errorf("expected no guess for input without delimiter lines")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvWriter writes one row of metadata per section, optionally including
+// the content.
+type csvWriter struct {
+	w              *csv.Writer
+	includeContent bool
+
+	articlesCount int
+	titles        map[string]int
+}
+
+func newCSVWriter(out io.Writer, separator rune, includeContent bool) *csvWriter {
+	w := csv.NewWriter(out)
+	w.Comma = separator
+
+	header := []string{"title", "dupe_index", "lines", "words", "first_line"}
+	if includeContent {
+		header = append(header, "content")
+	}
+	w.Write(header)
+
+	return &csvWriter{w: w, includeContent: includeContent, titles: make(map[string]int)}
+}
+
+func (w *csvWriter) WriteFile(title string, content []string, emptyLines int) {
+	w.articlesCount++
+	w.titles[title]++
+
+	lines := content[:len(content)-emptyLines]
+
+	words := 0
+	for _, line := range lines {
+		words += len(strings.Fields(line))
+	}
+
+	var firstLine string
+	if len(lines) > 0 {
+		firstLine = lines[0]
+	}
+
+	record := []string{
+		title,
+		strconv.Itoa(w.titles[title]),
+		strconv.Itoa(len(lines)),
+		strconv.Itoa(words),
+		firstLine,
+	}
+	if w.includeContent {
+		record = append(record, strings.Join(lines, "\n"))
+	}
+
+	w.w.Write(record)
+}
+
+func (w *csvWriter) ArticlesCount() int {
+	return w.articlesCount
+}
+
+func (w *csvWriter) Done() bool {
+	return false
+}
+
+// Flush writes any buffered data and returns the first error that
+// occurred while writing.
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// histogramWidth is the length of the longest histogram bar.
+const histogramWidth = 50
+
+var percentiles = []float64{50, 90, 95, 99, 100}
+
+// writeDetail prints a report on the distribution of section sizes, the
+// largest and smallest sections and the most duplicated titles. Unusual
+// sizes often point to malformed delimiters in the input.
+func writeDetail(out io.Writer, w *fileWriter, top int) {
+	if len(w.sections) == 0 {
+		fmt.Fprintln(out, "No sections found.")
+		return
+	}
+
+	lines := make([]int, len(w.sections))
+	bytes := make([]int, len(w.sections))
+	for idx, section := range w.sections {
+		lines[idx] = section.lines
+		bytes[idx] = section.bytes
+	}
+
+	fmt.Fprintln(out, "Lines per section:")
+	writeHistogram(out, lines)
+	writePercentiles(out, lines)
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Bytes per section:")
+	writeHistogram(out, bytes)
+	writePercentiles(out, bytes)
+
+	sections := append([]sectionSize(nil), w.sections...)
+	sort.SliceStable(sections, func(i, j int) bool {
+		if sections[i].lines != sections[j].lines {
+			return sections[i].lines > sections[j].lines
+		}
+		return sections[i].bytes > sections[j].bytes
+	})
+
+	n := top
+	if n > len(sections) {
+		n = len(sections)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Largest sections:\n")
+	for _, section := range sections[:n] {
+		fmt.Fprintf(out, "  %8d lines %10d bytes  %s\n", section.lines, section.bytes, section.title)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Smallest sections:\n")
+	for idx := len(sections) - 1; idx >= len(sections)-n; idx-- {
+		section := sections[idx]
+		fmt.Fprintf(out, "  %8d lines %10d bytes  %s\n", section.lines, section.bytes, section.title)
+	}
+
+	var dupes []string
+	for title, count := range w.titles {
+		if count > 1 {
+			dupes = append(dupes, title)
+		}
+	}
+	sort.Slice(dupes, func(i, j int) bool {
+		if w.titles[dupes[i]] != w.titles[dupes[j]] {
+			return w.titles[dupes[i]] > w.titles[dupes[j]]
+		}
+		return dupes[i] < dupes[j]
+	})
+	if len(dupes) > top {
+		dupes = dupes[:top]
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Most duplicated titles:\n")
+	if len(dupes) == 0 {
+		fmt.Fprintln(out, "  none")
+	}
+	for _, title := range dupes {
+		fmt.Fprintf(out, "  %8d times  %s\n", w.titles[title], title)
+	}
+}
+
+// writeHistogram prints a histogram with buckets growing in powers of two.
+func writeHistogram(out io.Writer, values []int) {
+	var buckets []int
+	first := -1
+
+	for _, value := range values {
+		bucket := histogramBucket(value)
+		for len(buckets) <= bucket {
+			buckets = append(buckets, 0)
+		}
+		buckets[bucket]++
+		if first == -1 || bucket < first {
+			first = bucket
+		}
+	}
+
+	max := 0
+	for _, count := range buckets {
+		if count > max {
+			max = count
+		}
+	}
+
+	for bucket := first; bucket < len(buckets); bucket++ {
+		count := buckets[bucket]
+		bar := int(math.Ceil(float64(count) / float64(max) * histogramWidth))
+		fmt.Fprintf(out, "  %21s %8d %s\n", bucketLabel(bucket), count, strings.Repeat("#", bar))
+	}
+}
+
+// histogramBucket returns 0 for 0, 1 for 1, 2 for 2-3, 3 for 4-7 and so on.
+func histogramBucket(value int) int {
+	bucket := 0
+	for value > 0 {
+		bucket++
+		value >>= 1
+	}
+	return bucket
+}
+
+func bucketLabel(bucket int) string {
+	if bucket <= 1 {
+		return fmt.Sprintf("%d", bucket)
+	}
+
+	from := 1 << uint(bucket-1)
+	return fmt.Sprintf("%d-%d", from, 2*from-1)
+}
+
+// writePercentiles prints percentiles using the nearest-rank method.
+func writePercentiles(out io.Writer, values []int) {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+
+	var parts []string
+	for _, p := range percentiles {
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		parts = append(parts, fmt.Sprintf("p%g=%d", p, sorted[rank]))
+	}
+
+	fmt.Fprintf(out, "  percentiles: %s\n", strings.Join(parts, " "))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// dryRunFileSystem pretends to write files. Instead, it prints the path
+// and size of each file, and whether it would be new, overwrite an
+// existing file or collide with a file written before. Behind a
+// manifestFileSystem, it also prints the files the policy skips.
+type dryRunFileSystem struct {
+	charset *charset
+	plan    io.Writer
+
+	filename string
+	size     byteCounter
+	out      io.Writer
+
+	written map[string]string // filenameKey of each path written so far
+
+	newCount       int
+	overwriteCount int
+	collisionCount int
+	skipCount      int
+}
+
+func newDryRunFileSystem(cs *charset, plan io.Writer) *dryRunFileSystem {
+	return &dryRunFileSystem{charset: cs, plan: plan, written: make(map[string]string)}
+}
+
+func (fs *dryRunFileSystem) WriteOpen(filename string) error {
+	if fs.filename != "" {
+		panic("Can't open another file at the same time!")
+	}
+
+	fs.filename = filename
+	fs.size = 0
+	fs.out = &fs.size
+
+	if fs.charset != nil && fs.charset != charsetUTF8 {
+		fs.size.Write(fs.charset.bom)
+		fs.out = &encodingWriter{w: &fs.size, cs: fs.charset}
+	}
+
+	return nil
+}
+
+func (fs *dryRunFileSystem) Fprintln(line string) {
+	if fs.filename == "" {
+		panic("Can't write line before opening a file!")
+	}
+
+	fmt.Fprintln(fs.out, line)
+}
+
+func (fs *dryRunFileSystem) FlushClose() error {
+	if fs.filename == "" {
+		panic("Can't flush or close yet, no open file!")
+	}
+
+	status := "new"
+	note := ""
+
+	key := filenameKey(fs.filename)
+	if other, exists := fs.written[key]; exists {
+		status = "collision"
+		note = fmt.Sprintf(" (with %s)", other)
+		fs.collisionCount++
+	} else if _, err := os.Lstat(fs.filename); err == nil {
+		status = "overwrite"
+		fs.overwriteCount++
+	} else {
+		fs.newCount++
+	}
+	fs.written[key] = fs.filename
+
+	_, err := fmt.Fprintf(fs.plan, "%-9s %10d  %s%s\n", status, fs.size, fs.filename, note)
+
+	fs.filename = ""
+	fs.out = nil
+
+	return err
+}
+
+// Skip prints a file that is not written, with the size of the existing
+// file.
+func (fs *dryRunFileSystem) Skip(filename string, status string) {
+	var size int64
+	if info, err := os.Lstat(filename); err == nil {
+		size = info.Size()
+	}
+
+	fs.skipCount++
+	fs.written[filenameKey(filename)] = filename
+
+	fmt.Fprintf(fs.plan, "%-9s %10d  %s\n", status, size, filename)
+}
+
+// byteCounter is an io.Writer that only counts the bytes written.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// charset describes a character encoding that splitt0r can decode input
+// from and encode output to. Internally, everything is UTF-8.
+type charset struct {
+	name   string
+	bom    []byte
+	decode func(r *bufio.Reader) (rune, error)
+	encode func(b []byte, r rune) []byte
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// windows1252 maps the bytes 0x80 to 0x9F to their Unicode code points.
+// All other bytes are identical to Latin-1. Undefined bytes are mapped
+// to the corresponding C1 control character.
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+var (
+	charsetUTF8 = &charset{
+		name:   "utf-8",
+		decode: decodeUTF8,
+		encode: encodeUTF8,
+	}
+	charsetLatin1 = &charset{
+		name:   "latin1",
+		decode: decodeLatin1,
+		encode: encodeLatin1,
+	}
+	charsetWindows1252 = &charset{
+		name:   "windows-1252",
+		decode: decodeWindows1252,
+		encode: encodeWindows1252,
+	}
+	charsetUTF16LE = &charset{
+		name:   "utf-16le",
+		decode: decodeUTF16(false),
+		encode: encodeUTF16(false),
+	}
+	charsetUTF16BE = &charset{
+		name:   "utf-16be",
+		decode: decodeUTF16(true),
+		encode: encodeUTF16(true),
+	}
+	// charsetUTF16 is big endian (RFC 2781) and writes a BOM. When
+	// reading, a BOM in the input determines the actual byte order.
+	charsetUTF16 = &charset{
+		name:   "utf-16",
+		bom:    bomUTF16BE,
+		decode: decodeUTF16(true),
+		encode: encodeUTF16(true),
+	}
+)
+
+var charsets = map[string]*charset{
+	"utf-8":        charsetUTF8,
+	"utf8":         charsetUTF8,
+	"latin1":       charsetLatin1,
+	"latin-1":      charsetLatin1,
+	"iso-8859-1":   charsetLatin1,
+	"windows-1252": charsetWindows1252,
+	"cp1252":       charsetWindows1252,
+	"utf-16":       charsetUTF16,
+	"utf-16le":     charsetUTF16LE,
+	"utf-16be":     charsetUTF16BE,
+}
+
+// lookupCharset returns the charset with the given (case-insensitive)
+// name.
+func lookupCharset(name string) (*charset, error) {
+	cs, ok := charsets[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+	return cs, nil
+}
+
+// newDecodingReader returns a reader that converts the input from the
+// named encoding to UTF-8. If name is "auto", the encoding is guessed
+// from the input. In auto mode and for UTF-16, a byte order mark takes
+// precedence and is removed from the input.
+func newDecodingReader(r io.Reader, name string) (io.Reader, *charset, error) {
+	br := bufio.NewReader(r)
+
+	var cs *charset
+
+	if strings.ToLower(name) == "auto" {
+		cs = sniffCharset(br)
+	} else {
+		var err error
+		cs, err = lookupCharset(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cs == charsetUTF16 || cs == charsetUTF16LE || cs == charsetUTF16BE {
+			if bomCs := sniffBOM(br); bomCs != nil {
+				cs = bomCs
+			}
+		}
+	}
+
+	if cs == charsetUTF8 {
+		return br, cs, nil
+	}
+
+	return &decodingReader{src: br, cs: cs}, cs, nil
+}
+
+// sniffBOM checks if the input starts with a byte order mark. If it does,
+// the BOM is discarded and the matching charset is returned.
+func sniffBOM(br *bufio.Reader) *charset {
+	head, _ := br.Peek(3)
+
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		br.Discard(len(bomUTF8))
+		return charsetUTF8
+	case bytes.HasPrefix(head, bomUTF16LE):
+		br.Discard(len(bomUTF16LE))
+		return charsetUTF16LE
+	case bytes.HasPrefix(head, bomUTF16BE):
+		br.Discard(len(bomUTF16BE))
+		return charsetUTF16BE
+	}
+
+	return nil
+}
+
+// sniffCharset guesses the encoding of the input by looking at the
+// beginning of it: A BOM wins, lots of zero bytes mean UTF-16, valid
+// UTF-8 stays UTF-8 and anything else is treated as Windows-1252 (which
+// is a superset of the printable Latin-1 characters).
+func sniffCharset(br *bufio.Reader) *charset {
+	if cs := sniffBOM(br); cs != nil {
+		return cs
+	}
+
+	sample, err := br.Peek(4096)
+	truncated := err == nil
+
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+
+	pairs := len(sample) / 2
+	if pairs > 0 {
+		if oddZeros*4 > pairs && oddZeros > evenZeros {
+			return charsetUTF16LE
+		}
+		if evenZeros*4 > pairs && evenZeros > oddZeros {
+			return charsetUTF16BE
+		}
+	}
+
+	// The sample may end in the middle of a multi-byte character:
+	if start := lastRuneStart(sample); truncated && start >= 0 && !utf8.FullRune(sample[start:]) {
+		sample = sample[:start]
+	}
+
+	if utf8.Valid(sample) {
+		return charsetUTF8
+	}
+
+	return charsetWindows1252
+}
+
+// lastRuneStart returns the index of the first byte of the last
+// character in b, or -1 if there is none.
+func lastRuneStart(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodingReader converts input in any charset to UTF-8.
+type decodingReader struct {
+	src *bufio.Reader
+	cs  *charset
+	buf []byte
+	err error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	var runeBuf [utf8.UTFMax]byte
+
+	for len(d.buf) < len(p) && d.err == nil {
+		r, err := d.cs.decode(d.src)
+		if err != nil {
+			d.err = err
+			break
+		}
+		n := utf8.EncodeRune(runeBuf[:], r)
+		d.buf = append(d.buf, runeBuf[:n]...)
+	}
+
+	n := copy(p, d.buf)
+	d.buf = append(d.buf[:0], d.buf[n:]...)
+
+	if n == 0 && d.err != nil {
+		return 0, d.err
+	}
+
+	return n, nil
+}
+
+// encodingWriter converts UTF-8 to any charset. Every call to Write must
+// contain complete UTF-8 sequences.
+type encodingWriter struct {
+	w  io.Writer
+	cs *charset
+}
+
+func (e *encodingWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+
+	for i := 0; i < len(p); {
+		r, size := utf8.DecodeRune(p[i:])
+		out = e.cs.encode(out, r)
+		i += size
+	}
+
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func decodeUTF8(r *bufio.Reader) (rune, error) {
+	c, _, err := r.ReadRune()
+	return c, err
+}
+
+func encodeUTF8(b []byte, r rune) []byte {
+	var runeBuf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(runeBuf[:], r)
+	return append(b, runeBuf[:n]...)
+}
+
+func decodeLatin1(r *bufio.Reader) (rune, error) {
+	c, err := r.ReadByte()
+	return rune(c), err
+}
+
+func encodeLatin1(b []byte, r rune) []byte {
+	if r > 0xFF {
+		return append(b, '?')
+	}
+	return append(b, byte(r))
+}
+
+func decodeWindows1252(r *bufio.Reader) (rune, error) {
+	c, err := r.ReadByte()
+	if c >= 0x80 && c <= 0x9F {
+		return windows1252[c-0x80], err
+	}
+	return rune(c), err
+}
+
+func encodeWindows1252(b []byte, r rune) []byte {
+	if r < 0x80 || (r > 0x9F && r <= 0xFF) {
+		return append(b, byte(r))
+	}
+	for i, c := range windows1252 {
+		if c == r {
+			return append(b, byte(0x80+i))
+		}
+	}
+	return append(b, '?')
+}
+
+func decodeUTF16(bigEndian bool) func(r *bufio.Reader) (rune, error) {
+	readUnit := func(r *bufio.Reader) (rune, error) {
+		var unit [2]byte
+		if _, err := io.ReadFull(r, unit[:]); err != nil {
+			return 0, err
+		}
+		if bigEndian {
+			return rune(unit[0])<<8 | rune(unit[1]), nil
+		}
+		return rune(unit[1])<<8 | rune(unit[0]), nil
+	}
+
+	return func(r *bufio.Reader) (rune, error) {
+		c, err := readUnit(r)
+		if err == io.ErrUnexpectedEOF {
+			// dangling byte at the end of input
+			return utf8.RuneError, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if !utf16.IsSurrogate(c) {
+			return c, nil
+		}
+
+		c2, err := readUnit(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return utf8.RuneError, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return utf16.DecodeRune(c, c2), nil
+	}
+}
+
+func encodeUTF16(bigEndian bool) func(b []byte, r rune) []byte {
+	return func(b []byte, r rune) []byte {
+		for _, unit := range utf16.Encode([]rune{r}) {
+			if bigEndian {
+				b = append(b, byte(unit>>8), byte(unit))
+			} else {
+				b = append(b, byte(unit), byte(unit>>8))
+			}
+		}
+		return b
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecodeInput(t *testing.T) {
+	testCases := []struct {
+		name     string
+		encoding string
+		input    []byte
+		expected string
+		charset  *charset
+	}{
+		{"utf-8", "utf-8", []byte("caf\xc3\xa9"), "café", charsetUTF8},
+
+		{"latin1", "latin1", []byte("caf\xe9"), "café", charsetLatin1},
+
+		{"windows-1252", "windows-1252", []byte("\x93caf\xe9\x94"), "“café”", charsetWindows1252},
+
+		{"utf-16le", "utf-16le", []byte("c\x00a\x00f\x00\xe9\x00"), "café", charsetUTF16LE},
+
+		{"utf-16be", "utf-16be", []byte("\x00c\x00a\x00f\x00\xe9"), "café", charsetUTF16BE},
+
+		{"utf-16 surrogates", "utf-16be", []byte("\xd8\x3d\xde\x00"), "😀", charsetUTF16BE},
+
+		{"utf-16 bom", "utf-16", []byte("\xff\xfec\x00a\x00f\x00\xe9\x00"), "café", charsetUTF16LE},
+
+		{"auto utf-8", "auto", []byte("caf\xc3\xa9"), "café", charsetUTF8},
+
+		{"auto utf-8 bom", "auto", []byte("\xef\xbb\xbfcaf\xc3\xa9"), "café", charsetUTF8},
+
+		{"auto windows-1252", "auto", []byte("caf\xe9"), "café", charsetWindows1252},
+
+		{"auto utf-16le", "auto", []byte("c\x00a\x00f\x00\xe9\x00"), "café", charsetUTF16LE},
+
+		{"auto utf-16be bom", "auto", []byte("\xfe\xff\x00c\x00a\x00f\x00\xe9"), "café", charsetUTF16BE},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, cs, err := newDecodingReader(bytes.NewReader(tc.input), tc.encoding)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if string(actual) != tc.expected {
+				t.Errorf("expected: \"%s\", actual: \"%s\".", tc.expected, actual)
+			}
+
+			if cs != tc.charset {
+				t.Errorf("expected charset: %s, actual: %s.", tc.charset.name, cs.name)
+			}
+		})
+	}
+}
+
+func TestDecodeInputUnknownEncoding(t *testing.T) {
+	_, _, err := newDecodingReader(bytes.NewReader(nil), "ebcdic")
+	if err == nil {
+		t.Fatalf("expected error for unknown encoding")
+	}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	testCases := []struct {
+		encoding string
+		input    string
+		expected []byte
+	}{
+		{"latin1", "café €", []byte("caf\xe9 ?")},
+
+		{"windows-1252", "café €", []byte("caf\xe9 \x80")},
+
+		{"utf-16le", "café", []byte("c\x00a\x00f\x00\xe9\x00")},
+
+		{"utf-16be", "😀", []byte("\xd8\x3d\xde\x00")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.encoding, func(t *testing.T) {
+			cs, err := lookupCharset(tc.encoding)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			b := &bytes.Buffer{}
+			w := &encodingWriter{w: b, cs: cs}
+			w.Write([]byte(tc.input))
+
+			if !bytes.Equal(b.Bytes(), tc.expected) {
+				t.Errorf("expected: %q, actual: %q.", tc.expected, b.Bytes())
+			}
+		})
+	}
+}
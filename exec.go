@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runCommand pipes the lines of a section through a shell command and
+// returns the lines the command printed. The title, the index of the
+// section among the sections written (which skips sections excluded by
+// filters) and its duplicate index (1 for the first section with a title)
+// are passed as environment variables.
+func runCommand(command string, lines []string, title string, index int, dupe int) ([]string, error) {
+	cmd := exec.Command("sh", "-c", command)
+
+	cmd.Env = append(os.Environ(),
+		"SPLITT0R_TITLE="+title,
+		fmt.Sprintf("SPLITT0R_INDEX=%d", index),
+		fmt.Sprintf("SPLITT0R_DUPE=%d", dupe),
+	)
+
+	var stdout, stderr bytes.Buffer
+
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	output := strings.TrimSuffix(stdout.String(), "\n")
+	if output == "" {
+		return []string{}, nil
+	}
+
+	return strings.Split(output, "\n"), nil
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"sync/atomic"
 )
 
 type fileSystem interface {
@@ -13,10 +16,20 @@ type fileSystem interface {
 }
 
 // osFileSystem is a simple wrapper around the file system, so we can
-// mock it out when testing.
+// mock it out when testing. Lines are encoded using charset, if set.
+//
+// Each file is written to a temporary file in the same directory first,
+// and renamed when it is complete, so nobody sees half-written files.
 type osFileSystem struct {
-	file *os.File
-	w    *bufio.Writer
+	charset *charset
+	sync    bool   // sync each file to disk before renaming it
+	written *int64 // bytes of all complete files, if set (updated atomically)
+
+	filename string // final name of the current file
+	file     *os.File
+	size     *countingWriter
+	w        *bufio.Writer
+	out      io.Writer
 }
 
 func (fs *osFileSystem) WriteOpen(filename string) error {
@@ -24,24 +37,48 @@ func (fs *osFileSystem) WriteOpen(filename string) error {
 		panic("Can't open another file at the same time!")
 	}
 
-	var err error
-	fs.file, err = os.Create(filename)
+	// nested sections are written to subdirectories:
+	err := os.MkdirAll(path.Dir(filename), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fs.file, err = os.OpenFile(tempFilename(filename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 
 	if err != nil {
 		return err
 	}
 
-	fs.w = bufio.NewWriter(fs.file)
+	fs.filename = filename
+	fs.size = &countingWriter{w: fs.file}
+	fs.w = bufio.NewWriter(fs.size)
+	fs.out = fs.w
+
+	if fs.charset != nil && fs.charset != charsetUTF8 {
+		fs.w.Write(fs.charset.bom)
+		fs.out = &encodingWriter{w: fs.w, cs: fs.charset}
+	}
 
 	return nil
 }
 
+// tempCount makes temporary filenames unique within the process, in case
+// several workers write the same file.
+var tempCount uint64
+
+// tempFilename returns the name of a hidden temporary file used while
+// writing filename.
+func tempFilename(filename string) string {
+	count := atomic.AddUint64(&tempCount, 1)
+	return path.Join(path.Dir(filename), fmt.Sprintf(".%s.%d-%d.tmp", path.Base(filename), os.Getpid(), count))
+}
+
 func (fs *osFileSystem) Fprintln(line string) {
 	if fs.file == nil {
 		panic("Can't write line before opening a file!")
 	}
 
-	fmt.Fprintln(fs.w, line)
+	fmt.Fprintln(fs.out, line)
 }
 
 func (fs *osFileSystem) FlushClose() error {
@@ -49,17 +86,49 @@ func (fs *osFileSystem) FlushClose() error {
 		panic("Can't flush or close yet, no open file!")
 	}
 
+	file := fs.file
+
 	defer func() {
+		fs.filename = ""
 		fs.file = nil
+		fs.size = nil
 		fs.w = nil
+		fs.out = nil
 	}()
 
 	err := fs.w.Flush()
+	if err == nil && fs.sync {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), fs.filename)
+	}
+
 	if err != nil {
+		os.Remove(file.Name())
 		return err
 	}
 
-	fs.file.Close()
+	if fs.written != nil {
+		atomic.AddInt64(fs.written, fs.size.count)
+	}
 
 	return nil
 }
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestOSFileSystemAtomicWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "sub", "foo.txt")
+	fs := &osFileSystem{sync: true}
+
+	if err := fs.WriteOpen(filename); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fs.Fprintln("foo")
+
+	if fileExists(filename) {
+		t.Fatalf("expected %s not to exist before FlushClose", filename)
+	}
+	if !fileExists(fs.file.Name()) {
+		t.Fatalf("expected temp file %s to exist", fs.file.Name())
+	}
+
+	if err := fs.FlushClose(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil || string(content) != "foo\n" {
+		t.Fatalf("unexpected content: %q, %v", content, err)
+	}
+
+	names, err := ioutil.ReadDir(path.Dir(filename))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("expected temp file to be gone, actual: %v, %v", names, err)
+	}
+
+	// renaming fails if the target is a directory, the temp file is removed:
+	if err := os.Mkdir(path.Join(dir, "bar.txt"), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := fs.WriteOpen(path.Join(dir, "bar.txt")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fs.Fprintln("bar")
+	tempname := fs.file.Name()
+
+	if err := fs.FlushClose(); err == nil {
+		t.Fatalf("expected error when renaming to a directory")
+	}
+	if fileExists(tempname) {
+		t.Fatalf("expected temp file to be removed after error")
+	}
+}
+
+func TestOSFileSystemBytesWritten(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	var written int64
+	fs := &osFileSystem{charset: charsetUTF16, written: &written}
+
+	if err := fs.WriteOpen(path.Join(dir, "foo.txt")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fs.Fprintln("foo")
+	if err := fs.FlushClose(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// BOM and 4 characters of 2 bytes each:
+	if written != 10 {
+		t.Fatalf("expected 10 bytes written, actual: %d", written)
+	}
+
+	// files that fail are not counted:
+	if err := os.Mkdir(path.Join(dir, "bar.txt"), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := fs.WriteOpen(path.Join(dir, "bar.txt")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fs.Fprintln("bar")
+	if err := fs.FlushClose(); err == nil {
+		t.Fatalf("expected error when renaming to a directory")
+	}
+
+	if written != 10 {
+		t.Fatalf("expected 10 bytes written, actual: %d", written)
+	}
+}
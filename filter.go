@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// sectionFilter decides which sections are passed on to the writer, based
+// on their title and content.
+type sectionFilter struct {
+	include []titleMatcher // title must match at least one, if any
+	exclude []titleMatcher // title must not match any
+	grep    *regexp.Regexp // content must match, if set
+	titles  map[string]bool
+
+	matchedCount int
+	skippedCount int
+}
+
+// titleMatcher matches titles against a regular expression or a glob
+// pattern.
+type titleMatcher func(title string) bool
+
+// newTitleMatcher creates a matcher for the given pattern, which is a
+// regular expression, or a glob pattern if prefixed with "glob:".
+func newTitleMatcher(pattern string) (titleMatcher, error) {
+	if strings.HasPrefix(pattern, "glob:") {
+		glob := strings.TrimPrefix(pattern, "glob:")
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, err
+		}
+		return func(title string) bool {
+			matched, _ := path.Match(glob, title)
+			return matched
+		}, nil
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return r.MatchString, nil
+}
+
+// readTitles reads a list of titles, one per line, ignoring empty lines.
+func readTitles(filename string) (map[string]bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	titles := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if title := strings.TrimSpace(scanner.Text()); title != "" {
+			titles[title] = true
+		}
+	}
+
+	return titles, scanner.Err()
+}
+
+// match checks if the section passes the filter and updates the counts.
+func (f *sectionFilter) match(title string, lines []string) bool {
+	if f.matches(title, lines) {
+		f.matchedCount++
+		return true
+	}
+
+	f.skippedCount++
+	return false
+}
+
+func (f *sectionFilter) matches(title string, lines []string) bool {
+	if f.titles != nil && !f.titles[title] {
+		return false
+	}
+
+	if len(f.include) > 0 {
+		included := false
+		for _, m := range f.include {
+			if m(title) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, m := range f.exclude {
+		if m(title) {
+			return false
+		}
+	}
+
+	if f.grep != nil {
+		for _, line := range lines {
+			if f.grep.MatchString(line) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func (f *sectionFilter) MatchedCount() int {
+	return f.matchedCount
+}
+
+func (f *sectionFilter) SkippedCount() int {
+	return f.skippedCount
+}
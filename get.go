@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// sectionGetter prints the content of the n-th section with the given
+// title, and then tells the parser to stop. An empty title matches any
+// section.
+type sectionGetter struct {
+	title string
+	n     int
+	out   io.Writer
+
+	articlesCount int
+	count         int
+	found         bool
+}
+
+func newSectionGetter(title string, n int, out io.Writer) *sectionGetter {
+	return &sectionGetter{title: title, n: n, out: out}
+}
+
+func (g *sectionGetter) WriteFile(title string, content []string, emptyLines int) {
+	g.articlesCount++
+
+	if g.found || (g.title != "" && title != g.title) {
+		return
+	}
+
+	g.count++
+	if g.count < g.n {
+		return
+	}
+
+	for _, line := range content[:len(content)-emptyLines] {
+		fmt.Fprintln(g.out, line)
+	}
+
+	g.found = true
+}
+
+func (g *sectionGetter) ArticlesCount() int {
+	return g.articlesCount
+}
+
+func (g *sectionGetter) Done() bool {
+	return g.found
+}
+
+func (g *sectionGetter) Found() bool {
+	return g.found
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const indexHeader = "splitt0r-index 1"
+
+// indexEntry locates a section in the input file. The region starts at the
+// first line of the section and ends before the next delimiter line, so
+// it may include trailing empty lines.
+type indexEntry struct {
+	title  string
+	offset int64
+	length int64
+}
+
+// sectionIndex is a sidecar file listing the sections of an input file,
+// so single sections can be read without scanning the whole input.
+type sectionIndex struct {
+	size    int64  // size of the input file
+	modTime int64  // modification time of the input file (Unix nanoseconds)
+	hash    string // SHA-256 of the input file
+	options string // fingerprint of the options used to split the input
+
+	entries []indexEntry
+}
+
+// indexWriter is a sectionWriter that collects index entries.
+type indexWriter struct {
+	index *sectionIndex
+
+	loc sectionLocation
+}
+
+func newIndexWriter() *indexWriter {
+	return &indexWriter{index: &sectionIndex{}}
+}
+
+func (w *indexWriter) SetLocation(loc sectionLocation) {
+	w.loc = loc
+}
+
+func (w *indexWriter) WriteFile(title string, content []string, emptyLines int) {
+	w.index.entries = append(w.index.entries, indexEntry{title: title, offset: w.loc.offset, length: w.loc.length})
+}
+
+func (w *indexWriter) ArticlesCount() int {
+	return len(w.index.entries)
+}
+
+func (w *indexWriter) Done() bool {
+	return false
+}
+
+// hashFile returns the SHA-256 hash of a file.
+func hashFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// setFileInfo records the size, modification time and hash of the input
+// file, which are used to detect a stale index.
+func (idx *sectionIndex) setFileInfo(info os.FileInfo, hash string) {
+	idx.size = info.Size()
+	idx.modTime = info.ModTime().UnixNano()
+	idx.hash = hash
+}
+
+// optionsFingerprint returns a hash of the values of the given flags, so
+// an index created with different options is not used.
+func optionsFingerprint(flags *flag.FlagSet, names []string) string {
+	h := sha256.New()
+	for _, name := range names {
+		if f := flags.Lookup(name); f != nil {
+			fmt.Fprintf(h, "%s=%s\n", name, f.Value.String())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isStale checks if the input file has changed since the index was
+// created. The hash is only compared if verify is set, since that
+// requires reading the whole input.
+func (idx *sectionIndex) isStale(filename string, verify bool) (bool, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+
+	if info.Size() != idx.size || info.ModTime().UnixNano() != idx.modTime {
+		return true, nil
+	}
+
+	if verify {
+		hash, err := hashFile(filename)
+		if err != nil {
+			return false, err
+		}
+		return hash != idx.hash, nil
+	}
+
+	return false, nil
+}
+
+// lookup returns the n-th entry with the given title.
+func (idx *sectionIndex) lookup(title string, n int) (indexEntry, bool) {
+	count := 0
+	for _, entry := range idx.entries {
+		if entry.title == title {
+			count++
+			if count == n {
+				return entry, true
+			}
+		}
+	}
+	return indexEntry{}, false
+}
+
+func (idx *sectionIndex) write(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+
+	fmt.Fprintln(w, indexHeader)
+	fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", idx.size, idx.modTime, idx.hash, idx.options)
+
+	for _, entry := range idx.entries {
+		fmt.Fprintf(w, "%d\t%d\t%s\n", entry.offset, entry.length, entry.title)
+	}
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func readIndex(filename string) (*sectionIndex, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	if !scanner.Scan() || scanner.Text() != indexHeader {
+		return nil, fmt.Errorf("%s is not a splitt0r index", filename)
+	}
+
+	idx := &sectionIndex{}
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%s is incomplete", filename)
+	}
+
+	// indexes without options are never used, but are still valid:
+	fields := strings.Split(scanner.Text(), "\t")
+	if len(fields) != 3 && len(fields) != 4 {
+		return nil, fmt.Errorf("invalid file info in %s", filename)
+	}
+	if len(fields) == 4 {
+		idx.options = fields[3]
+	}
+
+	idx.size, err = strconv.ParseInt(fields[0], 10, 64)
+	if err == nil {
+		idx.modTime, err = strconv.ParseInt(fields[1], 10, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid file info in %s: %s", filename, err)
+	}
+	idx.hash = fields[2]
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid entry in %s: %s", filename, scanner.Text())
+		}
+
+		var entry indexEntry
+
+		entry.offset, err = strconv.ParseInt(fields[0], 10, 64)
+		if err == nil {
+			entry.length, err = strconv.ParseInt(fields[1], 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry in %s: %s", filename, err)
+		}
+		entry.title = fields[2]
+
+		idx.entries = append(idx.entries, entry)
+	}
+
+	return idx, scanner.Err()
+}
+
+// readSection returns a reader for the region of the input file described
+// by the entry.
+func readSection(file *os.File, entry indexEntry) io.Reader {
+	return io.NewSectionReader(file, entry.offset, entry.length)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	p := newParser('=', 5, false)
+	w := newIndexWriter()
+
+	p.parseFile(sl([]string{
+		"=====",
+		"foo foo",
+		"",
+		"=====",
+		"",
+		"bar bar",
+		"bar",
+		"=====",
+		"foo foo",
+	}), w)
+
+	expected := []indexEntry{
+		{"foo", 6, 9},
+		{"bar", 22, 12},
+		{"foo", 40, 8},
+	}
+
+	if !reflect.DeepEqual(expected, w.index.entries) {
+		t.Fatalf("expected: %v, actual: %v", expected, w.index.entries)
+	}
+}
+
+func TestIndexGet(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "input.txt")
+	if err := ioutil.WriteFile(filename, []byte("foo foo\n=====\nbar bar\nbar\n\n=====\nfoo foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	info, _ := file.Stat()
+	hash, _ := hashFile(filename)
+
+	w := newIndexWriter()
+	newParser('=', 5, false).parseFile(sl([]string{"foo foo", "=====", "bar bar", "bar", "", "=====", "foo foo"}), w)
+	w.index.setFileInfo(info, hash)
+	w.index.options = "options"
+
+	idxFilename := filename + ".idx"
+	if err := w.index.write(idxFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := readIndex(idxFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(w.index, idx) {
+		t.Fatalf("expected: %v, actual: %v", w.index, idx)
+	}
+
+	if stale, err := idx.isStale(filename, true); stale || err != nil {
+		t.Fatalf("expected index not to be stale (error: %v)", err)
+	}
+
+	b := &bytes.Buffer{}
+	getIndexed(newParser('=', 5, false), file, openIndex(idxFilename, filename, "options", false), "bar", 1, b)
+
+	if b.String() != "bar bar\nbar\n" {
+		t.Fatalf("expected bar section, got: %q", b.String())
+	}
+
+	if openIndex(idxFilename, filename, "other options", false) != nil {
+		t.Fatal("expected index with different options not to be used")
+	}
+
+	ioutil.WriteFile(filename, []byte("changed\n"), 0644)
+
+	if stale, err := idx.isStale(filename, false); !stale || err != nil {
+		t.Fatalf("expected index to be stale (error: %v)", err)
+	}
+
+	if openIndex(idxFilename, filename, "options", false) != nil {
+		t.Fatal("expected stale index not to be used")
+	}
+}
+
+func TestOptionsFingerprint(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("char", "=", "")
+	flags.String("mode", "delimiter", "")
+
+	names := []string{"char", "mode", "unknown"}
+	before := optionsFingerprint(flags, names)
+
+	if optionsFingerprint(flags, names) != before {
+		t.Fatal("expected the same fingerprint for the same options")
+	}
+
+	flags.Parse([]string{"-char", "-"})
+
+	if optionsFingerprint(flags, names) == before {
+		t.Fatal("expected a different fingerprint for different options")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// jsonSection is the JSON representation of a section.
+type jsonSection struct {
+	Title      string `json:"title"`
+	DupeIndex  int    `json:"dupe_index"`
+	Content    string `json:"content"`
+	Lines      int    `json:"lines"`
+	SourceLine int    `json:"source_line"`
+}
+
+// jsonWriter writes one JSON object per section (JSON Lines).
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+
+	loc           sectionLocation
+	articlesCount int
+	titles        map[string]int
+}
+
+func newJSONWriter(out io.Writer) *jsonWriter {
+	w := bufio.NewWriter(out)
+	return &jsonWriter{w: w, enc: json.NewEncoder(w), titles: make(map[string]int)}
+}
+
+func (w *jsonWriter) SetLocation(loc sectionLocation) {
+	w.loc = loc
+}
+
+func (w *jsonWriter) WriteFile(title string, content []string, emptyLines int) {
+	w.articlesCount++
+	w.titles[title]++
+
+	lines := content[:len(content)-emptyLines]
+
+	err := w.enc.Encode(jsonSection{
+		Title:      title,
+		DupeIndex:  w.titles[title],
+		Content:    strings.Join(lines, "\n"),
+		Lines:      len(lines),
+		SourceLine: w.loc.line,
+	})
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *jsonWriter) ArticlesCount() int {
+	return w.articlesCount
+}
+
+func (w *jsonWriter) Done() bool {
+	return false
+}
+
+// Flush writes any buffered data and returns the first error that
+// occurred while writing.
+func (w *jsonWriter) Flush() error {
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// lintProblem is something suspicious found in the input. Errors most
+// likely lead to wrong output, warnings might.
+type lintProblem struct {
+	line    int // 0 if unknown
+	isError bool
+	message string
+}
+
+// titleLine is a title and the line its section starts at.
+type titleLine struct {
+	raw   string // title before sanitizing
+	title string
+	line  int
+}
+
+// linter looks for suspicious structures in the input. It receives the
+// raw input lines as an io.Writer (for line endings and near-miss
+// delimiters) and the sections as a sectionWriter.
+type linter struct {
+	p *parser // delimiter settings and MediaWiki titles
+
+	checkDelimiters bool
+	checkWikiTitles bool
+	maxLines        int // 0: no limit
+	maxBytes        int // 0: no limit
+
+	problems []lintProblem
+
+	buf        []byte // incomplete last line
+	lineNumber int
+	crlfLines  int
+	lfLines    int
+	firstCRLF  int
+	firstLF    int
+
+	loc           sectionLocation
+	articlesCount int
+	filenames     map[string]titleLine
+	titles        map[string]bool // titles before sanitizing, duplicates are only reported once
+}
+
+func newLinter(p *parser) *linter {
+	return &linter{p: p, filenames: make(map[string]titleLine), titles: make(map[string]bool)}
+}
+
+func (l *linter) Write(b []byte) (int, error) {
+	l.buf = append(l.buf, b...)
+
+	for {
+		idx := bytes.IndexByte(l.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		l.checkLine(l.buf[:idx+1])
+		l.buf = l.buf[idx+1:]
+	}
+
+	return len(b), nil
+}
+
+// Finish checks the last line, if it did not end with a newline, and
+// the line endings of the whole input.
+func (l *linter) Finish() {
+	if len(l.buf) > 0 {
+		l.checkLine(l.buf)
+		l.buf = nil
+	}
+
+	if l.crlfLines > 0 && l.lfLines > 0 {
+		line := l.firstLF
+		if l.crlfLines < l.lfLines {
+			line = l.firstCRLF
+		}
+		l.warn(line, "mixed line endings: %d CRLF and %d LF lines", l.crlfLines, l.lfLines)
+	}
+}
+
+func (l *linter) checkLine(raw []byte) {
+	l.lineNumber++
+
+	line := string(raw)
+	if l.lineNumber == 1 {
+		line = strings.TrimPrefix(line, string(bom))
+	}
+
+	switch {
+	case strings.HasSuffix(line, "\r\n"):
+		l.crlfLines++
+		if l.firstCRLF == 0 {
+			l.firstCRLF = l.lineNumber
+		}
+	case strings.HasSuffix(line, "\n"):
+		l.lfLines++
+		if l.firstLF == 0 {
+			l.firstLF = l.lineNumber
+		}
+	}
+
+	if l.checkDelimiters {
+		l.checkDelimiter(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// checkDelimiter reports lines that look like delimiter lines, but are
+// not recognized as such by the parser.
+func (l *linter) checkDelimiter(line string) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || l.p.isDelimiter(line) {
+		return
+	}
+
+	first := []rune(trimmed)[0]
+	length := 0
+	same := true
+	delimiter := false
+
+	for _, char := range trimmed {
+		if !unicode.IsPunct(char) && !unicode.IsSymbol(char) {
+			return
+		}
+		length++
+		same = same && char == first
+		delimiter = delimiter || l.isDelimiterChar(char)
+	}
+
+	switch {
+	case !delimiter:
+		return
+	case same && length > 1 && length < l.p.delimiterLen:
+		l.warn(l.lineNumber, "delimiter line is too short (%d < %d chars)", length, l.p.delimiterLen)
+	case same && length >= l.p.delimiterLen:
+		l.error(l.lineNumber, "delimiter line starts with whitespace")
+	case !same && length >= l.p.delimiterLen:
+		l.error(l.lineNumber, "delimiter line contains mixed chars")
+	}
+}
+
+func (l *linter) isDelimiterChar(char rune) bool {
+	if char == l.p.delimiterChar {
+		return true
+	}
+	for _, level := range l.p.levels {
+		if char == level {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) SetLocation(loc sectionLocation) {
+	l.loc = loc
+}
+
+func (l *linter) WriteFile(title string, content []string, emptyLines int) {
+	l.articlesCount++
+
+	lines := len(content) - emptyLines
+	size := 0
+	for _, line := range content[:lines] {
+		size += len(line) + 1
+	}
+
+	if l.maxLines > 0 && lines > l.maxLines {
+		l.warn(l.loc.line, "section %s has %d lines (more than %d)", title, lines, l.maxLines)
+	}
+
+	if l.maxBytes > 0 && size > l.maxBytes {
+		l.warn(l.loc.line, "section %s has %d bytes (more than %d)", title, size, l.maxBytes)
+	}
+
+	if l.checkWikiTitles && lines > 0 {
+		line := content[0]
+		if l.p.stripInvisible {
+			line = removeInvisible(line)
+		}
+		if l.p.parseWikiTitle(line) == "" {
+			l.error(l.loc.line, "no MediaWiki title found, using %s", title)
+		}
+	}
+
+	// titles are compared before sanitizing, so different titles like
+	// AC/DC and AC_DC are caught:
+	raw := l.loc.title
+	if raw == "" {
+		raw = title
+	}
+
+	if l.titles[raw] {
+		return
+	}
+	l.titles[raw] = true
+
+	key := filenameKey(title)
+	if other, exists := l.filenames[key]; !exists {
+		l.filenames[key] = titleLine{raw, title, l.loc.line}
+	} else if other.title == title && other.raw != raw {
+		l.error(l.loc.line, "title %s has the same filename as %s (line %d)", raw, other.raw, other.line)
+	} else if other.title != title {
+		l.error(l.loc.line, "title %s has the same filename as %s (line %d) on case-insensitive or Windows file systems", raw, other.raw, other.line)
+	}
+}
+
+// filenameKey returns the same key for titles that end up in the same
+// file on case-insensitive file systems (and on Windows, which ignores
+// trailing dots and spaces).
+func filenameKey(title string) string {
+	return strings.ToLower(strings.TrimRight(title, ". "))
+}
+
+func (l *linter) ArticlesCount() int {
+	return l.articlesCount
+}
+
+func (l *linter) Done() bool {
+	return false
+}
+
+func (l *linter) warn(line int, format string, args ...interface{}) {
+	l.problems = append(l.problems, lintProblem{line, false, fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) error(line int, format string, args ...interface{}) {
+	l.problems = append(l.problems, lintProblem{line, true, fmt.Sprintf(format, args...)})
+}
+
+// ErrorsCount returns the number of problems that are errors.
+func (l *linter) ErrorsCount() int {
+	count := 0
+	for _, problem := range l.problems {
+		if problem.isError {
+			count++
+		}
+	}
+	return count
+}
+
+// Report prints all problems ordered by line number, prefixed with name
+// like compiler messages.
+func (l *linter) Report(out io.Writer, name string) {
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].line < l.problems[j].line
+	})
+
+	for _, problem := range l.problems {
+		severity := "warning"
+		if problem.isError {
+			severity = "error"
+		}
+
+		if problem.line > 0 {
+			fmt.Fprintf(out, "%s:%d: %s: %s\n", name, problem.line, severity, problem.message)
+		} else {
+			fmt.Fprintf(out, "%s: %s: %s\n", name, severity, problem.message)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	p := newParser('=', 5, true)
+	p.wikiFallback = firstWordFallback
+	l := newLinter(p)
+	l.checkDelimiters = true
+	l.checkWikiTitles = true
+	l.maxLines = 2
+
+	input := strings.NewReader(strings.Join([]string{
+		"'''Foo''' bar",
+		"===",
+		"  =====",
+		"==-==",
+		"=====",
+		"'''foo''' bar\r",
+		"=====",
+		"no title",
+		"=====",
+		"'''Bar''' baz",
+	}, "\n"))
+
+	p.parseFile(bufio.NewScanner(io.TeeReader(input, l)), l)
+	l.Finish()
+
+	b := &bytes.Buffer{}
+	l.Report(b, "input.txt")
+
+	expected := strings.Join([]string{
+		"input.txt:1: warning: section Foo has 4 lines (more than 2)",
+		"input.txt:2: warning: delimiter line is too short (3 < 5 chars)",
+		"input.txt:3: error: delimiter line starts with whitespace",
+		"input.txt:4: error: delimiter line contains mixed chars",
+		"input.txt:6: error: title foo has the same filename as Foo (line 1) on case-insensitive or Windows file systems",
+		"input.txt:6: warning: mixed line endings: 1 CRLF and 8 LF lines",
+		"input.txt:8: error: no MediaWiki title found, using no",
+		"",
+	}, "\n")
+
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, b.String())
+	}
+
+	if l.ErrorsCount() != 4 {
+		t.Errorf("expected 4 errors, actual: %d", l.ErrorsCount())
+	}
+}
+
+func TestLintSanitizedTitles(t *testing.T) {
+	p := newParser('=', 5, false)
+	l := newLinter(p)
+
+	p.parseFile(sl([]string{"AC/DC a", "=====", "AC_DC b", "=====", "AC_DC c"}), l)
+
+	b := &bytes.Buffer{}
+	l.Report(b, "input.txt")
+
+	expected := "input.txt:3: error: title AC_DC has the same filename as AC/DC (line 1)\n"
+
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, b.String())
+	}
+}
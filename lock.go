@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockName is the name of the lock file in the output directory.
+const lockName = ".splitt0r-lock"
+
+// lockOutputDir creates the output directory, if necessary, and a lock
+// file in it, so that two runs don't write to the same directory at the
+// same time. The lock file contains the process ID, so a lock left behind
+// by a run that has crashed is taken over. It returns the name of the
+// lock file.
+func lockOutputDir(outputDir string) (string, error) {
+	err := os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	filename := path.Join(outputDir, lockName)
+
+	// The lock file is written under a temporary name and then linked, so
+	// it is never seen without the process ID:
+	tmp := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	err = ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0666)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	for {
+		err = os.Link(tmp, filename)
+		if err == nil {
+			return filename, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+
+		pid, running := lockOwner(filename)
+		if running {
+			return "", fmt.Errorf("output directory %s is in use by process %d (if it is not, delete %s)", outputDir, pid, filename)
+		}
+
+		err = removeStaleLock(filename, pid)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// removeStaleLock removes the lock file left behind by process pid. Other
+// runs may try to take over the same lock at the same time, so the lock
+// file is moved away first and removed only if it still belongs to pid.
+// Otherwise, another run has just taken over and its lock is put back.
+func removeStaleLock(filename string, pid int) error {
+	stale := fmt.Sprintf("%s.%d.stale", filename, os.Getpid())
+
+	err := os.Rename(filename, stale)
+	if os.IsNotExist(err) {
+		// another run was faster:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	owner, running := lockOwner(stale)
+	if owner == pid && !running {
+		return os.Remove(stale)
+	}
+
+	err = os.Link(stale, filename)
+	os.Remove(stale)
+	if err != nil {
+		return fmt.Errorf("lock file %s was taken over by several processes at the same time", filename)
+	}
+	return fmt.Errorf("output directory %s is in use by process %d (if it is not, delete %s)", path.Dir(filename), owner, filename)
+}
+
+// unlockOutputDir removes the lock file, unless another run has taken it
+// over.
+func unlockOutputDir(filename string) {
+	if pid, _ := lockOwner(filename); pid == os.Getpid() {
+		os.Remove(filename)
+	}
+}
+
+// lockOwner returns the process ID in the lock file and whether that
+// process is still running. Unreadable lock files count as running, and
+// so does every process on Windows, where signal 0 can't be sent.
+func lockOwner(filename string) (int, bool) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, true
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, true
+	}
+
+	if runtime.GOOS == "windows" {
+		return pid, true
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return pid, false
+	}
+
+	// signal 0 only checks if the process exists:
+	err = process.Signal(syscall.Signal(0))
+	return pid, err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLockOutputDir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// a lock left behind by a process that is not running anymore:
+	filename := path.Join(dir, lockName)
+	if err := ioutil.WriteFile(filename, []byte("999999999\n"), 0666); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lockFilename, err := lockOutputDir(dir)
+	if err != nil || lockFilename != filename {
+		t.Fatalf("expected stale lock to be taken over, actual: %s, %v", lockFilename, err)
+	}
+
+	if pid, _ := lockOwner(filename); pid != os.Getpid() {
+		t.Fatalf("expected lock to belong to this process, actual: %d", pid)
+	}
+
+	if _, err := lockOutputDir(dir); err == nil {
+		t.Fatal("expected an error for a lock held by a running process")
+	}
+
+	unlockOutputDir(filename)
+
+	names, err := ioutil.ReadDir(dir)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("expected no files to be left, actual: %v, %v", names, err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const manifestHeader = "splitt0r-manifest 1"
+
+// manifestName is the name of the manifest file in the output directory.
+const manifestName = ".splitt0r-manifest"
+
+// manifest maps the paths of output files, relative to the output
+// directory, to the SHA-256 hash of their content.
+type manifest map[string]string
+
+// outputPolicy decides what happens to files in the output directory.
+type outputPolicy int
+
+const (
+	emptyPolicy     outputPolicy = iota // the output directory must be empty
+	forcePolicy                         // overwrite existing files
+	noClobberPolicy                     // skip existing files
+	updatePolicy                        // only write new and changed files
+)
+
+// skipRecorder is implemented by file systems that report the files a
+// policy doesn't write, like the dry run.
+type skipRecorder interface {
+	Skip(filename string, status string)
+}
+
+// manifestFileSystem records the hash of each file written in the
+// manifest of the output directory, so later runs know which files they
+// may clean up or skip because they are unchanged.
+type manifestFileSystem struct {
+	fs        fileSystem
+	outputDir string
+	encoding  string // output encoding, part of each hash
+	policy    outputPolicy
+
+	old manifest
+	new manifest
+
+	filename string
+	lines    []string
+
+	addedCount     int
+	changedCount   int
+	unchangedCount int
+	skippedCount   int
+	removedCount   int
+}
+
+func newManifestFileSystem(fs fileSystem, outputDir string, encoding string, policy outputPolicy) *manifestFileSystem {
+	return &manifestFileSystem{fs: fs, outputDir: path.Clean(outputDir), encoding: encoding, policy: policy, old: make(manifest), new: make(manifest)}
+}
+
+func (m *manifestFileSystem) WriteOpen(filename string) error {
+	if m.filename != "" {
+		panic("Can't open another file at the same time!")
+	}
+
+	m.filename = filename
+	m.lines = m.lines[:0]
+
+	return nil
+}
+
+func (m *manifestFileSystem) Fprintln(line string) {
+	if m.filename == "" {
+		panic("Can't write line before opening a file!")
+	}
+
+	m.lines = append(m.lines, line)
+}
+
+func (m *manifestFileSystem) FlushClose() error {
+	if m.filename == "" {
+		panic("Can't flush or close yet, no open file!")
+	}
+
+	filename := m.filename
+	m.filename = ""
+
+	h := sha256.New()
+	fmt.Fprintln(h, m.encoding)
+	for _, line := range m.lines {
+		fmt.Fprintln(h, line)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	rel := strings.TrimPrefix(filename, m.outputDir+"/")
+
+	oldHash, known := m.old[rel]
+	exists := fileExists(filename)
+
+	switch {
+	case m.policy == noClobberPolicy && exists:
+		m.skippedCount++
+		m.skip(filename, "skip")
+		return nil
+	case m.policy == updatePolicy && known && exists && oldHash == hash:
+		m.new[rel] = hash
+		m.unchangedCount++
+		m.skip(filename, "unchanged")
+		return nil
+	case known && exists:
+		m.changedCount++
+	default:
+		m.addedCount++
+	}
+
+	m.new[rel] = hash
+
+	if err := m.fs.WriteOpen(filename); err != nil {
+		return err
+	}
+	for _, line := range m.lines {
+		m.fs.Fprintln(line)
+	}
+	return m.fs.FlushClose()
+}
+
+func (m *manifestFileSystem) skip(filename string, status string) {
+	if r, ok := m.fs.(skipRecorder); ok {
+		r.Skip(filename, status)
+	}
+}
+
+// removeStale handles the files of the previous run that were not
+// written again. They are deleted if remove is set, otherwise they are
+// kept in the manifest.
+func (m *manifestFileSystem) removeStale(remove bool) error {
+	for rel, hash := range m.old {
+		if _, ok := m.new[rel]; ok {
+			continue
+		}
+
+		m.removedCount++
+
+		if !remove {
+			m.new[rel] = hash
+			continue
+		}
+
+		err := os.Remove(path.Join(m.outputDir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readManifest(filename string) (manifest, error) {
+	m := make(manifest)
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	if !scanner.Scan() || scanner.Text() != manifestHeader {
+		return nil, fmt.Errorf("%s is not a splitt0r manifest", filename)
+	}
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid entry in %s: %s", filename, scanner.Text())
+		}
+		m[fields[1]] = fields[0]
+	}
+
+	return m, scanner.Err()
+}
+
+func (m manifest) write(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+
+	fmt.Fprintln(w, manifestHeader)
+
+	paths := make([]string, 0, len(m))
+	for rel := range m {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		fmt.Fprintf(w, "%s\t%s\n", m[rel], rel)
+	}
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// cleanOutputDir deletes the files listed in the manifest of a previous
+// run, the manifest itself and directories that become empty. Other files
+// are kept.
+func cleanOutputDir(outputDir string, dupesDir string) (int, error) {
+	filename := path.Join(outputDir, manifestName)
+
+	m, err := readManifest(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	for rel := range m {
+		err := os.Remove(path.Join(outputDir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+
+		// fails for directories that are not empty:
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if os.Remove(path.Join(outputDir, dir)) != nil {
+				break
+			}
+		}
+	}
+
+	os.Remove(dupesDir)
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	return len(m), nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	split := func(input []string, remove bool) *manifestFileSystem {
+		old, err := readManifest(path.Join(dir, manifestName))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		fs := newManifestFileSystem(&osFileSystem{}, dir, "utf-8", updatePolicy)
+		fs.old = old
+		p := newParser('=', 5, false)
+		w := newFileWriter(fs, true, false, dir, ".txt", path.Join(dir, "dupes"))
+
+		p.parseFile(sl(input), w)
+
+		if err := fs.removeStale(remove); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := fs.new.write(path.Join(dir, manifestName)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return fs
+	}
+
+	expectCounts := func(fs *manifestFileSystem, added, changed, removed, unchanged int) {
+		if fs.addedCount != added || fs.changedCount != changed || fs.removedCount != removed || fs.unchangedCount != unchanged {
+			t.Fatalf("expected %d added, %d changed, %d removed, %d unchanged, actual: %d, %d, %d, %d",
+				added, changed, removed, unchanged, fs.addedCount, fs.changedCount, fs.removedCount, fs.unchangedCount)
+		}
+	}
+
+	fs := split([]string{"foo a", "=====", "bar b", "=====", "baz c"}, false)
+	expectCounts(fs, 3, 0, 0, 0)
+
+	fs = split([]string{"foo a", "=====", "bar x", "=====", "qux"}, false)
+	expectCounts(fs, 1, 1, 1, 1)
+
+	if !fileExists(path.Join(dir, "baz.txt")) {
+		t.Fatalf("expected baz.txt to be kept")
+	}
+
+	fs = split([]string{"foo a", "=====", "bar x"}, true)
+	expectCounts(fs, 0, 0, 2, 2)
+
+	if fileExists(path.Join(dir, "baz.txt")) || fileExists(path.Join(dir, "qux.txt")) {
+		t.Fatalf("expected baz.txt and qux.txt to be deleted")
+	}
+
+	content, err := ioutil.ReadFile(path.Join(dir, "bar.txt"))
+	if err != nil || string(content) != "bar x\n" {
+		t.Fatalf("unexpected content of bar.txt: %q, %v", content, err)
+	}
+
+	if len(fs.new) != 2 {
+		t.Fatalf("expected 2 manifest entries, actual: %v", fs.new)
+	}
+}
+
+func TestNoClobberAndClean(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "foo.txt"), []byte("mine\n"), 0666); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fs := newManifestFileSystem(&osFileSystem{}, dir, "utf-8", noClobberPolicy)
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, false, dir, ".txt", path.Join(dir, "dupes"))
+
+	p.parseFile(sl([]string{"foo a", "=====", "bar b", "=====", "foo c"}), w)
+
+	if fs.skippedCount != 1 || fs.addedCount != 2 {
+		t.Fatalf("expected 1 skipped and 2 added files, actual: %d, %d", fs.skippedCount, fs.addedCount)
+	}
+
+	if err := fs.new.write(path.Join(dir, manifestName)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	count, err := cleanOutputDir(dir, path.Join(dir, "dupes"))
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 files to be deleted, actual: %d, %v", count, err)
+	}
+
+	names, err := ioutil.ReadDir(dir)
+	if err != nil || len(names) != 1 || names[0].Name() != "foo.txt" {
+		t.Fatalf("expected only foo.txt to be kept, actual: %v, %v", names, err)
+	}
+}
+
+func TestManifestDryRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "foo.txt"), []byte("mine\n"), 0666); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b := &bytes.Buffer{}
+	dryRunFS := newDryRunFileSystem(charsetUTF8, b)
+	fs := newManifestFileSystem(dryRunFS, dir, "utf-8", noClobberPolicy)
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, false, dir, ".txt", path.Join(dir, "dupes"))
+
+	p.parseFile(sl([]string{"foo a", "=====", "bar b"}), w)
+
+	expected := "skip               5  " + path.Join(dir, "foo.txt") + "\n" +
+		"new                6  " + path.Join(dir, "bar.txt") + "\n"
+
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\n", expected, b.String())
+	}
+
+	if dryRunFS.skipCount != 1 || dryRunFS.newCount != 1 || fs.skippedCount != 1 {
+		t.Fatalf("unexpected counts: %d skipped, %d new", dryRunFS.skipCount, dryRunFS.newCount)
+	}
+
+	if names, _ := ioutil.ReadDir(dir); len(names) != 1 {
+		t.Fatalf("expected dry run not to write files, actual: %v", names)
+	}
+}
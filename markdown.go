@@ -0,0 +1,104 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownHeadingRegexp = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	markdownFenceRegexp   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})(.*)$")
+)
+
+// parseMarkdown keeps track of fenced code blocks and starts a new
+// section at every heading up to the configured level. It returns true
+// if the line has been consumed.
+func (p *parser) parseMarkdown(line string) bool {
+	if fence, info, ok := markdownFence(line); ok {
+		if p.fence == "" {
+			p.fence = fence
+		} else if fence[0] == p.fence[0] && len(fence) >= len(p.fence) && strings.TrimSpace(info) == "" {
+			p.fence = ""
+		}
+		return false
+	}
+
+	if p.fence != "" {
+		return false
+	}
+
+	level, title := markdownHeading(line)
+	if level == 0 || level > p.headingLevel {
+		return false
+	}
+
+	switch p.state {
+	case content:
+		fallthrough
+	case empty:
+		p.write()
+	}
+
+	if p.stripInvisible {
+		title = removeInvisible(title)
+	}
+
+	p.title = title
+	p.sectionStart = p.lineStart
+	p.sectionLine = p.lineNumber
+	p.lines = make([]string, 0)
+	p.emptyLines = 0
+
+	if p.keepHeadings {
+		p.state = content
+		p.lines = append(p.lines, line)
+	} else {
+		p.state = heading
+	}
+
+	return true
+}
+
+// parseHeading skips empty lines after a heading which is not kept in
+// the output. The title has already been set from the heading.
+func (p *parser) parseHeading(line string) {
+	if p.isEmpty(line) {
+		// skip
+	} else {
+		p.state = content
+		p.lines = append(p.lines, line)
+	}
+}
+
+// markdownHeading returns the level and text of an ATX heading
+// ("## Text"), or level 0 if the line is not a heading or the heading
+// text is empty.
+func markdownHeading(line string) (int, string) {
+	m := markdownHeadingRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return 0, ""
+	}
+
+	title := strings.TrimSpace(m[2])
+	if title == "" {
+		return 0, ""
+	}
+
+	return len(m[1]), title
+}
+
+// markdownFence returns the fence characters and info string if the line
+// opens or closes a fenced code block.
+func markdownFence(line string) (string, string, bool) {
+	m := markdownFenceRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+
+	// backtick fences may not contain backticks in the info string
+	if m[1][0] == '`' && strings.Contains(m[2], "`") {
+		return "", "", false
+	}
+
+	return m[1], m[2], true
+}
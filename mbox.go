@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// mboxTitleFields lists the headers that can be used as the title of a
+// message, in the order they are tried.
+var mboxTitleFields = []string{"message-id", "subject", "date"}
+
+var (
+	// mboxSeparatorRegexp matches "From sender asctime" lines, ignoring
+	// anything after the time of day (seconds, time zone, year...).
+	mboxSeparatorRegexp  = regexp.MustCompile(`^From \S+ +[A-Z][a-z]{2} +[A-Z][a-z]{2} +\d{1,2} +\d{1,2}:\d{2}`)
+	mboxQuotedFromRegexp = regexp.MustCompile("^>+From ")
+)
+
+// maxMboxTitleLen limits the length of titles taken from headers, which
+// can be much longer than sensible filenames.
+const maxMboxTitleLen = 100
+
+// parseMbox starts a new message at every "From " separator line and
+// collects the headers used for the title. It returns true if the line
+// has been consumed.
+func (p *parser) parseMbox(line string) bool {
+	if p.isMboxSeparator(line) {
+		switch p.state {
+		case content:
+			fallthrough
+		case empty:
+			p.write()
+		}
+
+		p.state = delimiter
+		p.lines = make([]string, 0)
+		p.emptyLines = 0
+		p.mboxHeaders = make(map[string]string)
+		p.mboxLastHeader = ""
+		p.mboxInHeaders = true
+
+		return true
+	}
+
+	if p.mboxInHeaders {
+		p.parseMboxHeader(line)
+	}
+
+	return false
+}
+
+// isMboxSeparator checks if line is a "From " line at the beginning of
+// the input or after an empty line.
+func (p *parser) isMboxSeparator(line string) bool {
+	if p.state != leadingEmpty && p.state != empty {
+		return false
+	}
+
+	return mboxSeparatorRegexp.MatchString(line)
+}
+
+func (p *parser) parseMboxHeader(line string) {
+	if p.isEmpty(line) {
+		p.mboxInHeaders = false
+		return
+	}
+
+	if line[0] == ' ' || line[0] == '\t' {
+		// folded header
+		if p.mboxLastHeader != "" {
+			p.mboxHeaders[p.mboxLastHeader] += " " + strings.TrimSpace(line)
+		}
+		return
+	}
+
+	idx := strings.Index(line, ":")
+	if idx <= 0 {
+		p.mboxLastHeader = ""
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(line[:idx]))
+	p.mboxLastHeader = ""
+
+	for _, field := range mboxTitleFields {
+		if name == field {
+			p.mboxHeaders[name] = strings.TrimSpace(line[idx+1:])
+			p.mboxLastHeader = name
+			break
+		}
+	}
+}
+
+// mboxTitle returns the title of the current message, using the first
+// header that is present, starting with the configured one.
+func (p *parser) mboxTitle() string {
+	fields := append([]string{p.mboxTitleField}, mboxTitleFields...)
+
+	for _, field := range fields {
+		value, ok := p.mboxHeaders[field]
+		if !ok {
+			continue
+		}
+
+		if title := formatMboxTitle(field, value); title != "" {
+			return title
+		}
+	}
+
+	return fmt.Sprintf("message %d", p.writer.ArticlesCount()+1)
+}
+
+// formatMboxTitle turns a header value into something that can be used as
+// filename.
+func formatMboxTitle(field string, value string) string {
+	switch field {
+	case "message-id":
+		value = strings.Trim(value, "<> ")
+	case "subject":
+		decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+		if err == nil {
+			value = decoded
+		}
+	case "date":
+		date, err := mail.ParseDate(value)
+		if err == nil {
+			value = date.Format("2006-01-02 15-04-05")
+		}
+	}
+
+	value = strings.Join(strings.Fields(value), " ")
+
+	if utf8.RuneCountInString(value) > maxMboxTitleLen {
+		value = string([]rune(value)[:maxMboxTitleLen])
+	}
+
+	return sanitizeTitle(value)
+}
+
+// unquoteMboxFrom removes one level of quoting from ">From " lines, as
+// done by the mboxrd format.
+func unquoteMboxFrom(line string) string {
+	if mboxQuotedFromRegexp.MatchString(line) {
+		return line[1:]
+	}
+	return line
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"bufio"
-	"log"
-	"regexp"
+	"fmt"
+	"path"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type parserState int
@@ -15,45 +16,138 @@ const (
 	leadingEmpty
 	content
 	empty
+	heading
 )
 
-type wikiMarkup int
+// bom is the byte order mark, which is also known as zero-width
+// no-break space.
+const bom = '\uFEFF'
 
-const (
-	none = iota
-	italic
-	bold
-	boldItalic
-)
+// untitled is the title of sections whose first line is empty after
+// removing invisible characters.
+const untitled = "untitled"
+
+// sectionWriter receives the sections found by the parser.
+type sectionWriter interface {
+	WriteFile(title string, content []string, emptyLines int)
+	ArticlesCount() int
+	// Done tells the parser to stop reading the input.
+	Done() bool
+}
+
+// sectionLocation describes where a section is located in the input.
+type sectionLocation struct {
+	offset int64  // byte offset of the first line
+	length int64  // number of bytes up to the next boundary line
+	line   int    // number of the first line, starting at 1
+	title  string // title before sanitizing, see sanitizeTitle
+}
+
+// locationWriter is a sectionWriter that is interested in where each
+// section is located in the input. SetLocation is called before WriteFile.
+type locationWriter interface {
+	SetLocation(loc sectionLocation)
+}
 
 type parser struct {
 	delimiterChar rune
 	delimiterLen  int
 	wikiMode      bool
 
+	wikiOrder         []string     // precedence of MediaWiki title kinds
+	wikiLinkLabel     bool         // use link labels instead of targets
+	wikiTemplateParam string       // template parameter containing the title
+	wikiFallback      wikiFallback // what to do if no title is found
+
+	stripInvisible bool // remove zero-width and bidi control chars from titles
+
+	markdownMode bool // split at Markdown headings instead of delimiters
+	headingLevel int  // maximum heading level that starts a new section
+	keepHeadings bool // keep heading lines in the output
+
+	mboxMode       bool   // split mbox files into messages
+	mboxTitleField string // header to use as title
+
+	namespaces []int // MediaWiki namespaces to include from XML dumps (empty: all)
+
+	levels    []rune // delimiter chars of nested levels below delimiterChar
+	indexName string // title for content preceding the first nested section
+
+	filter    *sectionFilter // decides which sections are written, if set
+	transform Transform      // applied to each section before writing, if set
+
 	state      parserState
 	title      string   // current title
 	lines      []string // current content
 	emptyLines int
+	fence      string   // opening fence of current code block, if any
+	level      int      // level of the last delimiter line
+	dirs       []string // directories of the enclosing sections
+
+	mboxHeaders    map[string]string // title headers of current message
+	mboxLastHeader string            // name of last header, for folding
+	mboxInHeaders  bool
 
-	writer *fileWriter
+	lineStart    int64 // byte offset of the current line
+	lineEnd      int64 // byte offset after the current line
+	sectionStart int64 // byte offset of the current section
+	lineNumber   int   // number of the current line
+	sectionLine  int   // number of the first line of the current section
+	eof          bool
+	err          error // stops parsing, e.g. if no title was found
+
+	writer sectionWriter
 }
 
 func newParser(char rune, len int, wiki bool) *parser {
 	return &parser{delimiterChar: char, delimiterLen: len, wikiMode: wiki}
 }
 
-func (p *parser) parseFile(scanner *bufio.Scanner, w *fileWriter) error {
+func (p *parser) parseFile(scanner *bufio.Scanner, w sectionWriter) error {
 	p.writer = w
 
 	p.state = leadingEmpty
 	p.title = ""
 	p.lines = make([]string, 0)
 	p.emptyLines = 0
+	p.fence = ""
+	p.level = 0
+	p.dirs = nil
+	p.mboxHeaders = make(map[string]string)
+	p.mboxLastHeader = ""
+	p.mboxInHeaders = true
+	p.lineStart = 0
+	p.lineEnd = 0
+	p.sectionStart = 0
+	p.lineNumber = 0
+	p.sectionLine = 0
+	p.eof = false
+	p.err = nil
+
+	scanner.Split(p.scanLines)
+
+	first := true
 
-	for scanner.Scan() {
+	for !p.writer.Done() && p.err == nil && scanner.Scan() {
 		line := scanner.Text()
 
+		if first {
+			// A UTF-8 BOM is not part of the content:
+			line = strings.TrimPrefix(line, string(bom))
+			first = false
+		}
+
+		if p.markdownMode && p.parseMarkdown(line) {
+			continue
+		}
+
+		if p.mboxMode {
+			if p.parseMbox(line) {
+				continue
+			}
+			line = unquoteMboxFrom(line)
+		}
+
 		switch p.state {
 		case delimiter:
 			p.parseDelimiter(line)
@@ -63,13 +157,25 @@ func (p *parser) parseFile(scanner *bufio.Scanner, w *fileWriter) error {
 			p.parseContent(line)
 		case empty:
 			p.parseEmpty(line)
+		case heading:
+			p.parseHeading(line)
 		}
 	}
 
+	if p.err != nil {
+		return p.err
+	}
+
 	if err := scanner.Err(); err != nil {
 		return err
 	}
 
+	if p.writer.Done() {
+		return nil
+	}
+
+	p.eof = true
+
 	// If input did not end with delimiter, pretend it did:
 
 	switch p.state {
@@ -86,10 +192,10 @@ func (p *parser) parseDelimiter(line string) {
 	if p.isEmpty(line) {
 		p.state = leadingEmpty
 	} else if p.isDelimiter(line) {
-		// skip
+		p.level = p.delimiterLevel(line)
 	} else {
 		p.state = content
-		p.title = p.parseTitle(line)
+		p.startSection(line)
 		p.lines = append(p.lines, line)
 	}
 }
@@ -98,11 +204,12 @@ func (p *parser) parseLeadingEmpty(line string) {
 		// skip
 	} else if p.isDelimiter(line) {
 		p.state = delimiter
+		p.level = p.delimiterLevel(line)
 		// discard empty lines:
 		p.lines = make([]string, 0)
 	} else {
 		p.state = content
-		p.title = p.parseTitle(line)
+		p.startSection(line)
 		p.lines = append(p.lines, line)
 	}
 }
@@ -114,6 +221,7 @@ func (p *parser) parseContent(line string) {
 	} else if p.isDelimiter(line) {
 		p.state = delimiter
 		p.write()
+		p.level = p.delimiterLevel(line)
 		p.lines = make([]string, 0)
 	} else {
 		p.lines = append(p.lines, line)
@@ -126,6 +234,7 @@ func (p *parser) parseEmpty(line string) {
 	} else if p.isDelimiter(line) {
 		p.state = delimiter
 		p.write()
+		p.level = p.delimiterLevel(line)
 		p.lines = make([]string, 0)
 		p.emptyLines = 0
 	} else {
@@ -140,63 +249,193 @@ func (p *parser) isEmpty(line string) bool {
 }
 
 func (p *parser) isDelimiter(line string) bool {
+	return p.delimiterLevel(line) >= 0
+}
+
+// delimiterLevel returns 0 if line is a top level delimiter line, 1 if it
+// is a delimiter line of the first nested level and so on, or -1 if line
+// is not a delimiter line at all.
+func (p *parser) delimiterLevel(line string) int {
+	if p.markdownMode || p.mboxMode {
+		return -1
+	}
+
 	trimmed := strings.TrimRightFunc(line, unicode.IsSpace)
 
 	if len(trimmed) < p.delimiterLen {
-		return false
+		return -1
+	}
+
+	first, _ := utf8.DecodeRuneInString(trimmed)
+
+	level := -1
+	if first == p.delimiterChar {
+		level = 0
+	} else {
+		for idx, char := range p.levels {
+			if char == first {
+				level = idx + 1
+				break
+			}
+		}
+	}
+
+	if level == -1 {
+		return -1
 	}
 
 	for _, char := range trimmed {
-		if char != p.delimiterChar {
-			return false
+		if char != first {
+			return -1
 		}
 	}
 
-	return true
+	return level
 }
 
-func (p *parser) parseTitle(line string) string {
-	if p.wikiMode {
-		rBoldItalic := regexp.MustCompile("''''(.+?)''''")
-		rBold := regexp.MustCompile("'''(.+?)'''")
-		rItalic := regexp.MustCompile("''(.+?)''")
+// startSection sets the title of the section that starts with line. When
+// splitting hierarchically, a section above the deepest level opens a new
+// directory named by its title.
+func (p *parser) startSection(line string) {
+	p.title, p.err = p.parseTitle(line)
+	p.sectionStart = p.lineStart
+	p.sectionLine = p.lineNumber
+
+	if len(p.levels) == 0 {
+		return
+	}
+
+	if p.level < len(p.dirs) {
+		p.dirs = p.dirs[:p.level]
+	}
 
-		idxBoldItalic := rBoldItalic.FindStringIndex(line)
-		idxBold := rBold.FindStringIndex(line)
-		idxItalic := rItalic.FindStringIndex(line)
+	if p.level < len(p.levels) {
+		p.dirs = append(p.dirs, sanitizeTitle(p.title))
+		if p.indexName != "" {
+			p.title = p.indexName
+		}
+	}
+}
 
-		idxMin := -1
-		var m wikiMarkup
+func (p *parser) parseTitle(line string) (string, error) {
+	if p.stripInvisible {
+		line = removeInvisible(line)
+	}
 
-		if idxBoldItalic != nil {
-			idxMin = idxBoldItalic[0]
-			m = boldItalic
+	if p.wikiMode {
+		if title := p.parseWikiTitle(line); title != "" {
+			return title, nil
 		}
-		if idxBold != nil && (idxBold[0] < idxMin || idxMin == -1) {
-			idxMin = idxBold[0]
-			m = bold
+
+		if p.wikiFallback == failFallback {
+			return "", &titleError{line: p.lineNumber, text: line}
 		}
-		if idxItalic != nil && (idxItalic[0] < idxMin || idxMin == -1) {
-			m = italic
+	}
+
+	// the line may consist of invisible characters only:
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return untitled, nil
+	}
+
+	firstWord := fields[0]
+	return firstWord, nil
+}
+
+// titleError means that no title was found and the MediaWiki fallback is
+// to fail.
+type titleError struct {
+	line int
+	text string
+}
+
+func (e *titleError) Error() string {
+	return fmt.Sprintf("no title with MediaWiki markup found in line %d: %s", e.line, e.text)
+}
+
+// removeInvisible removes zero-width and bidirectional text control
+// characters, which would otherwise end up in the filename unnoticed.
+func removeInvisible(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == bom,
+			r >= '\u200B' && r <= '\u200F', // zero-width space, (non-)joiner, LRM, RLM
+			r >= '\u202A' && r <= '\u202E', // bidi embeddings and overrides
+			r >= '\u2060' && r <= '\u2064', // word joiner, invisible operators
+			r >= '\u2066' && r <= '\u2069', // bidi isolates
+			r == '\u061C':                  // Arabic letter mark
+			return -1
 		}
+		return r
+	}, s)
+}
 
-		switch m {
-		case boldItalic:
-			return rBoldItalic.FindStringSubmatch(line)[1]
-		case bold:
-			return rBold.FindStringSubmatch(line)[1]
-		case italic:
-			return rItalic.FindStringSubmatch(line)[1]
+// sanitizeTitle makes sure a title can be used as a single path segment,
+// by replacing path separators and turning "." and ".." into "_" and "__".
+func sanitizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
 		}
+		return r
+	}, title)
 
-		log.Panicf("Error: No title with MediaWiki markup found in line: %s\n", line)
-		return ""
+	if title == "." || title == ".." {
+		title = strings.Repeat("_", len(title))
 	}
 
-	firstWord := strings.Fields(line)[0]
-	return firstWord
+	return title
 }
 
 func (p *parser) write() {
-	p.writer.WriteFile(p.title, p.lines, p.emptyLines)
+	title := p.title
+	if p.mboxMode {
+		title = p.mboxTitle()
+	}
+
+	if p.filter != nil && !p.filter.match(title, p.lines[:len(p.lines)-p.emptyLines]) {
+		return
+	}
+
+	lines, emptyLines := p.transformLines(title, p.lines, p.emptyLines)
+
+	// only the enclosing sections may create directories:
+	raw := title
+	title = sanitizeTitle(title)
+	if len(p.dirs) > 0 {
+		raw = path.Join(path.Join(p.dirs...), raw)
+		title = path.Join(path.Join(p.dirs...), title)
+	}
+
+	if lw, ok := p.writer.(locationWriter); ok {
+		end := p.lineStart
+		if p.eof {
+			end = p.lineEnd
+		}
+		lw.SetLocation(sectionLocation{offset: p.sectionStart, length: end - p.sectionStart, line: p.sectionLine, title: raw})
+	}
+
+	p.writer.WriteFile(title, lines, emptyLines)
+}
+
+// scanLines works like bufio.ScanLines, but keeps track of the byte offset
+// of each line in the input.
+func (p *parser) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token != nil {
+		p.lineStart = p.lineEnd
+		p.lineEnd += int64(advance)
+		p.lineNumber++
+	}
+	return advance, token, err
+}
+
+// transformLines applies the transform, if any, to the lines of a section
+// without its trailing empty lines.
+func (p *parser) transformLines(title string, lines []string, emptyLines int) ([]string, int) {
+	if p.transform == nil {
+		return lines, emptyLines
+	}
+
+	return p.transform.Transform(title, lines[:len(lines)-emptyLines]), 0
 }
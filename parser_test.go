@@ -25,7 +25,10 @@ func TestParseMediaWikiTitle(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.input, func(t *testing.T) {
-			actual := p.parseTitle(tc.input)
+			actual, err := p.parseTitle(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
 
 			if actual != tc.expected {
 				t.Errorf("expected: \"%s\", actual: \"%s\".", tc.expected, actual)
@@ -35,13 +38,139 @@ func TestParseMediaWikiTitle(t *testing.T) {
 }
 
 func TestParseMediaWikiTitleNotFound(t *testing.T) {
-	p := &parser{wikiMode: true}
+	// failing is the default:
+	p := newParser('=', 5, true)
+	w := newFileWriter(newMemoryFileSystem(), false, false, "output", ".txt", "output/dupes")
+
+	err := p.parseFile(sl([]string{
+		"123 ''foo''",
+		"=====",
+		"123",
+		"=====",
+		"456 ''bar''",
+	}), w)
+
+	titleErr, ok := err.(*titleError)
+	if !ok {
+		t.Fatalf("expected title error in MediaWiki mode when missing title, got: %v", err)
+	}
+
+	if titleErr.line != 3 || titleErr.Error() != "no title with MediaWiki markup found in line 3: 123" {
+		t.Errorf("unexpected error: %s", titleErr)
+	}
+
+	if w.ArticlesCount() != 1 {
+		t.Errorf("expected parsing to stop at the missing title, got %d articles", w.ArticlesCount())
+	}
+}
+
+func TestParseTitleStripInvisible(t *testing.T) {
+	testCases := []struct {
+		input    string
+		wiki     bool
+		expected string
+	}{
+		{"\ufefffoo bar", false, "foo"},
+
+		{"fo\u200bo bar", false, "foo"},
+
+		{"\u200b foo bar", false, "foo"},
+
+		{"\u202efoo\u202c bar", false, "foo"},
+
+		{"123 ''\u2066foo\u2069'' bar", true, "foo"},
+
+		{"\u200b\u200d", false, "untitled"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			p := &parser{wikiMode: tc.wiki, stripInvisible: true}
+
+			actual, err := p.parseTitle(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if actual != tc.expected {
+				t.Errorf("expected: \"%s\", actual: \"%s\".", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSplitInvisibleOnlyTitle(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.stripInvisible = true
+	w := newFileWriter(fs, true, false, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"\u200b",
+		"foo",
+		"=====",
+		"bar",
+	}), w)
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("parseTitle didn't panic as expected in MediaWiki mode when missing title.")
-		}
-	}()
+	expected := map[string]string{
+		"output/untitled.txt": "\u200b\nfoo\n",
+		"output/bar.txt":      "bar\n",
+	}
+
+	expect(t, expected, 2, 3, 0, 0, fs, w)
+}
+
+func TestParseMediaWikiTitleRich(t *testing.T) {
+	testCases := []struct {
+		input    string
+		order    []string
+		label    bool
+		expected string
+	}{
+		{"== Foo ==", nil, false, "Foo"},
+
+		{"=== Foo Bar ===", nil, false, "Foo Bar"},
 
-	p.parseTitle("123")
+		{"123 '''[[Foo]]''' bar", nil, false, "Foo"},
+
+		{"123 '''[[Foo|Bar]]''' baz", nil, false, "Foo"},
+
+		{"123 '''[[Foo|Bar]]''' baz", nil, true, "Bar"},
+
+		{"[[File:x.png|thumb]] [[Foo#Section|Bar]] [[Baz]]", nil, false, "Foo"},
+
+		{"{{Infobox|name=Bar|title=[[Foo|Foo Bar]]}} [[Baz]]", nil, true, "Foo Bar"},
+
+		{"{{Infobox|title=Foo}} ''bar''", nil, false, "bar"},
+
+		{"{{Infobox|title=Foo}} ''bar''", []string{"template", "markup"}, false, "Foo"},
+
+		{"== ''Foo'' ==", []string{"heading"}, false, "Foo"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			p := &parser{wikiMode: true, wikiOrder: tc.order, wikiLinkLabel: tc.label}
+
+			actual, err := p.parseTitle(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if actual != tc.expected {
+				t.Errorf("expected: \"%s\", actual: \"%s\".", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseMediaWikiTitleFallback(t *testing.T) {
+	p := &parser{wikiMode: true, wikiFallback: firstWordFallback}
+
+	actual, err := p.parseTitle("123 foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actual != "123" {
+		t.Errorf("expected: \"123\", actual: \"%s\".", actual)
+	}
 }
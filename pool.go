@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// fileJob is a complete file waiting to be written.
+type fileJob struct {
+	filename string
+	lines    []string
+}
+
+// poolFileSystem collects the lines of each file and hands the complete
+// file to one of several workers, which write files concurrently, each
+// using its own fileSystem. Titles and duplicate numbers are still
+// determined in input order before, so the result is the same as when
+// writing one file at a time. At most as many files as there are workers
+// wait to be written, to bound the memory used. After the first error,
+// the workers only drain the queue.
+type poolFileSystem struct {
+	jobs  chan fileJob
+	wg    sync.WaitGroup
+	close sync.Once
+
+	mutex sync.Mutex
+	err   error
+
+	filename string
+	lines    []string
+}
+
+func newPoolFileSystem(workers int, newFS func() fileSystem) *poolFileSystem {
+	pool := &poolFileSystem{jobs: make(chan fileJob, workers)}
+
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.work(newFS())
+	}
+
+	return pool
+}
+
+func (pool *poolFileSystem) work(fs fileSystem) {
+	defer pool.wg.Done()
+
+	for job := range pool.jobs {
+		if pool.Err() != nil {
+			continue
+		}
+
+		err := fs.WriteOpen(job.filename)
+		if err != nil {
+			pool.fail(fmt.Errorf("can't open file %s for writing: %s", job.filename, err))
+			continue
+		}
+
+		for _, line := range job.lines {
+			fs.Fprintln(line)
+		}
+
+		err = fs.FlushClose()
+		if err != nil {
+			pool.fail(fmt.Errorf("can't write to file %s: %s", job.filename, err))
+		}
+	}
+}
+
+// fail records the first error of any worker.
+func (pool *poolFileSystem) fail(err error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.err == nil {
+		pool.err = err
+	}
+}
+
+// Err returns the first error of any worker, if any.
+func (pool *poolFileSystem) Err() error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	return pool.err
+}
+
+func (pool *poolFileSystem) WriteOpen(filename string) error {
+	if pool.filename != "" {
+		panic("Can't open another file at the same time!")
+	}
+
+	pool.filename = filename
+	pool.lines = nil
+
+	return nil
+}
+
+func (pool *poolFileSystem) Fprintln(line string) {
+	if pool.filename == "" {
+		panic("Can't write line before opening a file!")
+	}
+
+	pool.lines = append(pool.lines, line)
+}
+
+// FlushClose hands the file to the workers. It blocks while all workers
+// are busy and the queue is full. It returns the error of an earlier file
+// that failed, since the workers don't write any more files after that.
+func (pool *poolFileSystem) FlushClose() error {
+	if pool.filename == "" {
+		panic("Can't flush or close yet, no open file!")
+	}
+
+	job := fileJob{filename: pool.filename, lines: pool.lines}
+
+	pool.filename = ""
+	pool.lines = nil
+
+	if err := pool.Err(); err != nil {
+		return err
+	}
+
+	pool.jobs <- job
+
+	return nil
+}
+
+// Wait waits until all files are written and returns the first error. The
+// pool can't be used afterwards, but Wait may be called again.
+func (pool *poolFileSystem) Wait() error {
+	pool.close.Do(func() {
+		close(pool.jobs)
+	})
+	pool.wg.Wait()
+
+	return pool.Err()
+}
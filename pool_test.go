@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestPoolFileSystem(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	pool := newPoolFileSystem(4, func() fileSystem {
+		return &osFileSystem{}
+	})
+	p := newParser('=', 5, false)
+	w := newFileWriter(pool, true, false, dir, ".txt", path.Join(dir, "dupes"))
+
+	var input []string
+	for i := 0; i < 100; i++ {
+		input = append(input, fmt.Sprintf("title%d %d", i%10, i), "=====")
+	}
+
+	p.parseFile(sl(input), w)
+	pool.Wait()
+
+	if w.ArticlesCount() != 100 || w.DupeFilesCount() != 90 {
+		t.Fatalf("unexpected counts: %d articles, %d dupe files", w.ArticlesCount(), w.DupeFilesCount())
+	}
+
+	// duplicates are numbered in input order:
+	for i := 0; i < 100; i++ {
+		filename := path.Join(dir, fmt.Sprintf("title%d.txt", i%10))
+		if i >= 10 {
+			filename = path.Join(dir, "dupes", fmt.Sprintf("title%d (%d).txt", i%10, i/10+1))
+		}
+
+		content, err := ioutil.ReadFile(filename)
+		expected := fmt.Sprintf("title%d %d\n", i%10, i)
+		if err != nil || string(content) != expected {
+			t.Fatalf("expected %s to contain %q, actual: %q, %v", filename, expected, content, err)
+		}
+	}
+}
+
+func TestPoolFileSystemError(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	pool := newPoolFileSystem(2, func() fileSystem {
+		return &osFileSystem{}
+	})
+
+	// the directory is a file, so every file fails:
+	if err := ioutil.WriteFile(path.Join(dir, "file"), nil, 0666); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if pool.WriteOpen(path.Join(dir, "file", fmt.Sprintf("%d.txt", i))) != nil {
+			t.Fatal("expected WriteOpen to succeed")
+		}
+		pool.Fprintln("foo")
+		if pool.FlushClose() != nil {
+			break
+		}
+	}
+
+	if err := pool.Wait(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if err := pool.Wait(); err == nil {
+		t.Fatal("expected the error again")
+	}
+}
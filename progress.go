@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ttyInterval is how often the progress line is updated on a terminal.
+const ttyInterval = 200 * time.Millisecond
+
+// countingReader counts the bytes read. The count may be queried from
+// another goroutine.
+type countingReader struct {
+	count int64 // first for atomic access on 32-bit platforms
+	r     io.Reader
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.count, int64(n))
+	return n, err
+}
+
+func (c *countingReader) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// progress periodically reports how much of the input has been read. On
+// a terminal, it keeps updating a single line. Otherwise, it logs a line
+// of key=value pairs every interval, which is easier to process. It is a
+// sectionWriter to count the sections.
+type progress struct {
+	sections int64 // first for atomic access on 32-bit platforms
+
+	input    *countingReader
+	total    int64 // size of the input, 0 if unknown
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+
+	start time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newProgress(input *countingReader, total int64, out *os.File, interval time.Duration) *progress {
+	p := &progress{
+		input:    input,
+		total:    total,
+		out:      out,
+		tty:      isTerminal(out),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if p.tty {
+		p.interval = ttyInterval
+	}
+
+	return p
+}
+
+// isTerminal checks if file is a terminal (or another character device).
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progress) Start() {
+	p.start = time.Now()
+	go p.run()
+}
+
+// Stop reports the final state and stops reporting.
+func (p *progress) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *progress) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	defer close(p.done)
+
+	for {
+		select {
+		case <-ticker.C:
+			p.report(false)
+		case <-p.stop:
+			p.report(true)
+			return
+		}
+	}
+}
+
+func (p *progress) report(final bool) {
+	read := p.input.Count()
+	sections := atomic.LoadInt64(&p.sections)
+	elapsed := time.Since(p.start)
+
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(sections) / elapsed.Seconds()
+	}
+
+	var eta time.Duration
+	if p.total > 0 && read > 0 && read < p.total {
+		eta = time.Duration(float64(elapsed) * float64(p.total-read) / float64(read))
+	}
+
+	if !p.tty {
+		line := fmt.Sprintf("progress bytes_read=%d", read)
+		if p.total > 0 {
+			line += fmt.Sprintf(" bytes_total=%d percent=%.1f", p.total, percent(read, p.total))
+		}
+		line += fmt.Sprintf(" sections=%d sections_per_second=%.0f", sections, rate)
+		if p.total > 0 {
+			line += fmt.Sprintf(" eta_seconds=%.0f", eta.Seconds())
+		}
+		log.New(p.out, "", log.LstdFlags).Println(line)
+		return
+	}
+
+	line := formatBytes(read)
+	if p.total > 0 {
+		line = fmt.Sprintf("%5.1f%%  %s / %s", percent(read, p.total), line, formatBytes(p.total))
+	}
+	line += fmt.Sprintf("  %d sections  %.0f sections/s", sections, rate)
+	if p.total > 0 && !final {
+		line += fmt.Sprintf("  ETA %s", eta/time.Second*time.Second)
+	}
+
+	// overwrite the previous line and clear the rest of it:
+	fmt.Fprintf(p.out, "\r%s\x1b[K", line)
+	if final {
+		fmt.Fprintln(p.out)
+	}
+}
+
+func percent(part int64, total int64) float64 {
+	return float64(part) * 100 / float64(total)
+}
+
+// formatBytes formats a number of bytes for humans, like 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (p *progress) WriteFile(title string, content []string, emptyLines int) {
+	atomic.AddInt64(&p.sections, 1)
+}
+
+func (p *progress) ArticlesCount() int {
+	return int(atomic.LoadInt64(&p.sections))
+}
+
+func (p *progress) Done() bool {
+	return false
+}
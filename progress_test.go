@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	counter := &countingReader{r: strings.NewReader("foo\n=====\nbar\n")}
+	b := &bytes.Buffer{}
+	p := &progress{input: counter, total: 28, out: b, start: time.Now()}
+
+	ioutil.ReadAll(counter)
+	p.WriteFile("foo", []string{"foo"}, 0)
+	p.WriteFile("bar", []string{"bar"}, 0)
+
+	p.report(false)
+
+	for _, expected := range []string{" progress bytes_read=14 bytes_total=28 percent=50.0 sections=2 ", " eta_seconds="} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected %q in %q", expected, b.String())
+		}
+	}
+
+	b.Reset()
+	p.tty = true
+	p.report(true)
+
+	if !strings.HasPrefix(b.String(), "\r 50.0%  14 B / 28 B  2 sections  ") || strings.Contains(b.String(), "ETA") {
+		t.Errorf("unexpected progress line %q", b.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+	for _, tc := range testCases {
+		if actual := formatBytes(tc.n); actual != tc.expected {
+			t.Errorf("expected: %s, actual: %s.", tc.expected, actual)
+		}
+	}
+}
@@ -2,96 +2,854 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// commands lists the subcommands besides splitting, which is the default.
+var commands = []string{"get", "index", "lint", "stats"}
+
+// indexOptions are the flags that determine the titles and offsets of the
+// sections in an index.
+var indexOptions = []string{
+	"char", "len", "levels", "index", "mode", "heading-level", "mbox-title",
+	"wiki", "wiki-order", "wiki-link", "wiki-template-param", "wiki-fallback", "strip-invisible",
+	"include-title", "exclude-title", "grep", "titles-from",
+}
+
+// cleanups run when the program exits, even after an error, see atExit.
+var cleanups []func()
+
 func main() {
-	filename := *flag.String("file", "", "input filename")
-	char := *flag.String("char", "=", "delimiter char")
-	delimiterLen := *flag.Int("len", 5, "minimum number of delimiter chars")
-	wikiMode := *flag.Bool("wiki", false, "detect titles with MediaWiki markup")
-	doWrite := *flag.Bool("write", false, "actually write output files")
-	doPrint := *flag.Bool("print", false, "just print titles")
-	doStats := *flag.Bool("stats", false, "just print statistics")
-	outputDir := *flag.String("outdir", "output", "output directory name")
-	outputExt := *flag.String("outext", ".txt", "output files extension")
-
-	flag.Parse()
+	start := time.Now()
+	defer runCleanups()
+
+	command, args := parseCommand(os.Args[1:])
+
+	filenameFlag := flag.String("file", "", "input filename")
+	charFlag := flag.String("char", "=", "delimiter char (auto: detect char and length from the input)")
+	levelsFlag := flag.String("levels", "", "delimiter chars of nested levels, top level first (overrides -char)")
+	indexFlag := flag.String("index", "_index", "title for text preceding the first nested section (empty: use first word)")
+	delimiterLenFlag := flag.Int("len", 5, "minimum number of delimiter chars")
+	modeFlag := flag.String("mode", "delimiter", "split mode (delimiter, markdown, mbox or xml)")
+	headingLevelFlag := flag.Int("heading-level", 1, "maximum Markdown heading level that starts a new file")
+	stripHeadingsFlag := flag.Bool("strip-headings", false, "don't copy Markdown headings to the output files")
+	mboxTitleFlag := flag.String("mbox-title", "message-id", "header to use as title in mbox mode (message-id, subject or date)")
+	namespacesFlag := flag.String("namespaces", "", "comma-separated MediaWiki namespaces to include in xml mode (default all)")
+	wikiModeFlag := flag.Bool("wiki", false, "detect titles with MediaWiki markup")
+	wikiOrderFlag := flag.String("wiki-order", strings.Join(defaultWikiOrder, ","), "precedence of MediaWiki titles (markup, heading, template, link)")
+	wikiLinkFlag := flag.String("wiki-link", "target", "use link target or label as MediaWiki title")
+	wikiTemplateParamFlag := flag.String("wiki-template-param", "title", "template parameter containing the MediaWiki title")
+	wikiFallbackFlag := flag.String("wiki-fallback", "fail", "if no MediaWiki title is found: fail or use first-word")
+	wikiOutputFlag := flag.String("wiki-output", "raw", "convert MediaWiki markup in output files to text or markdown, or keep it raw")
+	rawExtFlag := flag.String("raw-ext", "", "also write unconverted MediaWiki files with this extension")
+	transformFlag := flag.String("transform", "", "comma-separated transforms applied to each file ("+strings.Join(transformNames(), ", ")+")")
+	wrapWidthFlag := flag.Int("wrap-width", 80, "maximum line length for the wrap transform")
+	headerFlag := flag.String("header", "", "text prepended by the header transform ({title} is replaced by the title)")
+	jsonlFlag := flag.String("jsonl", "", "write sections as JSON Lines to this file (- for stdout)")
+	csvFlag := flag.String("csv", "", "write a CSV file with one row per section to this file (- for stdout)")
+	csvTabFlag := flag.Bool("csv-tab", false, "separate CSV columns by tabs (TSV)")
+	csvContentFlag := flag.Bool("csv-content", false, "include the content in the CSV file")
+	execFlag := flag.String("exec", "", "shell command to pipe the content of each file through")
+	doWriteFlag := flag.Bool("write", false, "actually write output files")
+	dryRunFlag := flag.Bool("dry-run", false, "print the files -write would create, without writing anything")
+	progressFlag := flag.Bool("progress", false, "report progress on stderr while splitting")
+	progressIntervalFlag := flag.Duration("progress-interval", 10*time.Second, "how often to report progress if stderr is not a terminal")
+	workersFlag := flag.Int("workers", 1, "number of files written concurrently")
+	fsyncFlag := flag.Bool("fsync", false, "sync each output file to disk before moving it into place")
+	cleanFlag := flag.Bool("clean", false, "delete the files of the previous run from the output directory first")
+	forceFlag := flag.Bool("force", false, "overwrite existing files in the output directory")
+	noClobberFlag := flag.Bool("no-clobber", false, "keep existing files in the output directory")
+	updateFlag := flag.Bool("update", false, "only write new and changed files to the output directory")
+	deleteFlag := flag.Bool("delete", false, "update: delete files of sections that no longer exist")
+	doPrintFlag := flag.Bool("print", false, "just print titles")
+	doStatsFlag := flag.Bool("stats", false, "just print statistics")
+	statsFormatFlag := flag.String("stats-format", "text", "statistics format (text, json or prometheus)")
+	detailFlag := flag.Bool("detail", false, "print section size histograms, percentiles and largest, smallest and most duplicated sections with the statistics (implies -stats)")
+	topFlag := flag.Int("top", 10, "number of sections listed by -detail")
+	statsFileFlag := flag.String("stats-file", "", "write statistics to this file (default: stderr for text, stdout otherwise)")
+	outputDirFlag := flag.String("outdir", "output", "output directory name")
+	outputExtFlag := flag.String("outext", ".txt", "output files extension")
+	stripInvisibleFlag := flag.Bool("strip-invisible", false, "remove zero-width and bidi control characters from titles")
+	inputEncodingFlag := flag.String("input-encoding", "utf-8", "input encoding (utf-8, latin1, windows-1252, utf-16, utf-16le, utf-16be or auto)")
+	outputEncodingFlag := flag.String("output-encoding", "utf-8", "output files encoding (utf-8, latin1, windows-1252, utf-16, utf-16le or utf-16be)")
+
+	var includeTitles, excludeTitles stringList
+	flag.Var(&includeTitles, "include-title", "only split sections with a title matching this regexp (or glob:PATTERN), can be repeated")
+	flag.Var(&excludeTitles, "exclude-title", "skip sections with a title matching this regexp (or glob:PATTERN), can be repeated")
+	grepFlag := flag.String("grep", "", "only split sections containing a line matching this regexp")
+	titlesFromFlag := flag.String("titles-from", "", "only split sections with a title listed in this file")
+
+	dupeFlag := flag.Int("n", 1, "get: print the n-th section with the given title")
+	idxFlag := flag.String("idx", "", "index file for get and index (default: input filename + .idx)")
+	maxLinesFlag := flag.Int("max-lines", 10000, "lint: warn about sections with more lines (0: no limit)")
+	maxBytesFlag := flag.Int("max-bytes", 1000000, "lint: warn about sections with more bytes (0: no limit)")
+	verifyFlag := flag.Bool("verify", false, "get: verify the input file hash before using the index")
+
+	flag.CommandLine.Parse(args)
+
+	filename := *filenameFlag
+	char := *charFlag
+	delimiterLen := *delimiterLenFlag
+	wikiMode := *wikiModeFlag
+	doWrite := *doWriteFlag
+	doPrint := *doPrintFlag
+	doStats := *doStatsFlag
+	outputDir := *outputDirFlag
+	outputExt := *outputExtFlag
+
+	if *progressIntervalFlag <= 0 {
+		fatal("Error: progress interval must be greater than 0")
+	}
+
+	if *workersFlag < 1 {
+		fatal("Error: number of workers must be 1 or greater")
+	}
 
 	if delimiterLen <= 0 {
-		log.Fatal("Error: delimiter length must be 1 or greater")
+		fatal("Error: delimiter length must be 1 or greater")
 	}
 
-	if len(char) != 1 {
-		log.Fatal("Error: delimiter must be a single character")
+	levels := []rune(*levelsFlag)
+	if len(levels) > 0 {
+		char = string(levels[0])
 	}
 
-	useStdin := filename == ""
+	autoChar := char == "auto" && len(levels) == 0
 
-	delimiterChar := []rune(char)[0]
+	if !autoChar && len([]rune(char)) != 1 {
+		fatal("Error: delimiter must be a single character or auto")
+	}
 
-	if !doWrite && !doPrint && !doStats {
+	switch *modeFlag {
+	case "delimiter", "markdown", "xml":
+	case "mbox":
+		if !isFlagSet("outext") {
+			outputExt = ".eml"
+		}
+	default:
+		fatalf("Error: unknown mode %s\n", *modeFlag)
+	}
+
+	if len(levels) > 0 && *modeFlag != "delimiter" {
+		fatal("Error: -levels requires -mode delimiter")
+	}
+
+	mboxTitleField := strings.ToLower(*mboxTitleFlag)
+	if mboxTitleField != "message-id" && mboxTitleField != "subject" && mboxTitleField != "date" {
+		fatalf("Error: unknown mbox title header %s\n", *mboxTitleFlag)
+	}
+
+	switch *statsFormatFlag {
+	case "text", "json", "prometheus":
+	default:
+		fatalf("Error: unknown statistics format %s\n", *statsFormatFlag)
+	}
+
+	if *detailFlag && *statsFormatFlag != "text" {
+		fatal("Error: -detail requires the text statistics format")
+	}
+
+	// the details are printed with the statistics:
+	if *detailFlag {
 		doStats = true
 	}
 
-	dupesDir := path.Join(outputDir, "dupes")
+	if *topFlag < 0 {
+		fatal("Error: -top must be 0 or greater")
+	}
 
-	if doWrite {
-		prepareOutputDirs(outputDir, dupesDir)
+	if *headingLevelFlag < 1 || *headingLevelFlag > 6 {
+		fatal("Error: heading level must be between 1 and 6")
+	}
+
+	outputCharset, err := lookupCharset(*outputEncodingFlag)
+	if err != nil {
+		fatalf("Error: %s\n", err)
+	}
+
+	var wikiOrder []string
+	for _, kind := range strings.Split(*wikiOrderFlag, ",") {
+		kind = strings.TrimSpace(kind)
+		if !isWikiTitleKind(kind) {
+			fatalf("Error: unknown MediaWiki title kind %s\n", kind)
+		}
+		wikiOrder = append(wikiOrder, kind)
+	}
+
+	if *wikiLinkFlag != "target" && *wikiLinkFlag != "label" {
+		fatal("Error: MediaWiki link title must be target or label")
 	}
 
-	var scanner *bufio.Scanner
+	var wikiFallback wikiFallback
+	switch *wikiFallbackFlag {
+	case "first-word":
+		wikiFallback = firstWordFallback
+	case "fail":
+		wikiFallback = failFallback
+	default:
+		fatalf("Error: unknown MediaWiki fallback %s\n", *wikiFallbackFlag)
+	}
+
+	var wikiOutput wikiOutput
+	switch *wikiOutputFlag {
+	case "raw":
+		wikiOutput = rawOutput
+	case "text":
+		wikiOutput = textOutput
+	case "markdown":
+		wikiOutput = markdownOutput
+	default:
+		fatalf("Error: unknown MediaWiki output format %s\n", *wikiOutputFlag)
+	}
+
+	if wikiOutput != rawOutput && !wikiMode && *modeFlag != "xml" {
+		fatal("Error: MediaWiki output conversion requires -wiki or -mode xml")
+	}
+
+	var transformList []string
+	for _, name := range strings.Split(*transformFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			transformList = append(transformList, name)
+		}
+	}
+
+	transform, err := newTransformChain(transformList, transformConfig{wrapWidth: *wrapWidthFlag, header: *headerFlag})
+	if err != nil {
+		fatalf("Error: %s\n", err)
+	}
+
+	filter, err := newFilter(includeTitles, excludeTitles, *grepFlag, *titlesFromFlag)
+	if err != nil {
+		fatalf("Error: %s\n", err)
+	}
+
+	namespaces, err := parseNamespaces(*namespacesFlag)
+	if err != nil {
+		fatalf("Error: invalid namespaces %s: %s\n", *namespacesFlag, err)
+	}
+
+	var delimiterChar rune
+	if !autoChar {
+		delimiterChar = []rune(char)[0]
+	}
 
-	if useStdin {
-		scanner = bufio.NewScanner(os.Stdin)
+	idxFilename := *idxFlag
+	if idxFilename == "" {
+		idxFilename = filename + ".idx"
+	}
+
+	useIndex := *inputEncodingFlag == "utf-8" && *modeFlag != "xml"
+
+	if command == "index" && (filename == "" || !useIndex) {
+		fatal("Error: index requires -file, UTF-8 input and a mode other than xml")
+	}
+
+	var input io.Reader
+	var file *os.File
+
+	if filename == "" {
+		input = os.Stdin
 	} else {
-		file, err := os.Open(filename)
+		file, err = os.Open(filename)
 		if err != nil {
-			log.Fatalf("Error opening file %s:\n%s", filename, err)
+			fatalf("Error opening file %s:\n%s", filename, err)
 		}
 		defer file.Close()
-		scanner = bufio.NewScanner(file)
+		input = file
 	}
 
-	writer := newFileWriter(&osFileSystem{}, doWrite, doPrint, outputDir, outputExt, dupesDir)
-	p := newParser(delimiterChar, delimiterLen, wikiMode)
+	// get reads the section directly if there is an up-to-date index,
+	// otherwise it scans the input like any other command:
+	var idx *sectionIndex
+	if command == "get" && filename != "" && useIndex && fileExists(idxFilename) {
+		idx = openIndex(idxFilename, filename, optionsFingerprint(flag.CommandLine, indexOptions), *verifyFlag)
+	}
+
+	counter := &countingReader{r: input}
+	input = counter
 
-	err := p.parseFile(scanner, writer)
+	hash := sha256.New()
+	if command == "index" {
+		input = io.TeeReader(input, hash)
+	}
 
+	input, _, err = newDecodingReader(input, *inputEncodingFlag)
 	if err != nil {
-		if useStdin {
-			log.Fatalf("Error reading from stdin: %s\n", err)
+		fatalf("Error: %s\n", err)
+	}
+
+	if autoChar && *modeFlag == "delimiter" {
+		var guess delimiterGuess
+		var ok bool
+
+		input, guess, ok, err = detectDelimiter(input)
+		checkReadError(err, filename)
+
+		if !ok {
+			fatal("Error: no delimiter lines found, please specify -char")
+		}
+
+		delimiterChar = guess.char
+		if !isFlagSet("len") {
+			delimiterLen = guess.len
+		}
+
+		log.Printf("Detected delimiter %q with a minimum length of %d in %d lines (confidence: %.0f%%)\n",
+			guess.char, guess.len, guess.lines, guess.confidence*100)
+	}
+
+	if command == "stats" {
+		doWrite = false
+		doPrint = false
+		doStats = true
+	}
+
+	// bytesWritten counts the bytes of the files written, after encoding:
+	var bytesWritten int64
+
+	var fs fileSystem = &osFileSystem{charset: outputCharset, sync: *fsyncFlag, written: &bytesWritten}
+
+	var dryRunFS *dryRunFileSystem
+	if *dryRunFlag {
+		doWrite = true
+		dryRunFS = newDryRunFileSystem(outputCharset, os.Stdout)
+		fs = dryRunFS
+	}
+
+	policy := emptyPolicy
+	policyCount := 0
+	if *cleanFlag {
+		// files that are left after cleaning were not created by splitt0r:
+		policy = noClobberPolicy
+		policyCount++
+	}
+	if *forceFlag {
+		policy = forcePolicy
+		policyCount++
+	}
+	if *noClobberFlag {
+		policy = noClobberPolicy
+		policyCount++
+	}
+	if *updateFlag {
+		policy = updatePolicy
+		policyCount++
+		doWrite = true
+	}
+
+	if policyCount > 1 {
+		fatal("Error: only one of -clean, -force, -no-clobber and -update can be used")
+	}
+	if command != "split" && (policyCount > 0 || *dryRunFlag) {
+		fatalf("Error: -clean, -force, -no-clobber, -update and -dry-run can't be used with the %s command\n", command)
+	}
+	if *cleanFlag && *dryRunFlag {
+		fatal("Error: -clean can't be combined with -dry-run")
+	}
+	if *execFlag != "" && *dryRunFlag {
+		fatal("Error: -exec can't be combined with -dry-run, since the commands would run")
+	}
+	if policyCount > 0 && !doWrite {
+		fatal("Error: -clean, -force and -no-clobber require -write or -dry-run")
+	}
+	if *deleteFlag && !*updateFlag {
+		fatal("Error: -delete requires -update")
+	}
+
+	manifestFilename := path.Join(outputDir, manifestName)
+
+	var pool *poolFileSystem
+	var manifestFS *manifestFileSystem
+	if doWrite {
+		if *workersFlag > 1 && dryRunFS == nil {
+			pool = newPoolFileSystem(*workersFlag, func() fileSystem {
+				return &osFileSystem{charset: outputCharset, sync: *fsyncFlag, written: &bytesWritten}
+			})
+			fs = pool
+		}
+
+		manifestFS = newManifestFileSystem(fs, outputDir, outputCharset.name, policy)
+		fs = manifestFS
+	}
+
+	if !doWrite && !doPrint && !doStats && *jsonlFlag == "" && *csvFlag == "" {
+		doStats = true
+	}
+
+	dupesDir := path.Join(outputDir, "dupes")
+
+	writer := newFileWriter(fs, doWrite, doPrint, outputDir, outputExt, dupesDir)
+	writer.wikiOutput = wikiOutput
+	writer.rawExt = *rawExtFlag
+	writer.command = *execFlag
+	p := newParser(delimiterChar, delimiterLen, wikiMode)
+	p.wikiOrder = wikiOrder
+	p.wikiLinkLabel = *wikiLinkFlag == "label"
+	p.wikiTemplateParam = *wikiTemplateParamFlag
+	p.wikiFallback = wikiFallback
+	p.stripInvisible = *stripInvisibleFlag
+	p.markdownMode = *modeFlag == "markdown"
+	p.headingLevel = *headingLevelFlag
+	p.keepHeadings = !*stripHeadingsFlag
+	p.mboxMode = *modeFlag == "mbox"
+	p.mboxTitleField = mboxTitleField
+	if len(levels) > 1 {
+		p.levels = levels[1:]
+		p.indexName = *indexFlag
+	}
+
+	p.namespaces = namespaces
+	p.filter = filter
+	if len(transform) > 0 {
+		p.transform = transform
+	}
+
+	if command == "get" {
+		if flag.NArg() != 1 {
+			fatal("Error: please specify exactly one title: get [options] TITLE")
+		}
+
+		var out io.Writer = os.Stdout
+		if outputCharset != charsetUTF8 {
+			out = &encodingWriter{w: os.Stdout, cs: outputCharset}
+		}
+
+		if idx != nil {
+			getIndexed(p, file, idx, flag.Arg(0), *dupeFlag, out)
+			return
+		}
+
+		getter := newSectionGetter(flag.Arg(0), *dupeFlag, out)
+
+		err = parseInput(p, *modeFlag, input, getter)
+		checkReadError(err, filename)
+
+		if !getter.Found() {
+			fatalf("Error: section %s (%d) not found\n", flag.Arg(0), *dupeFlag)
+		}
+		return
+	}
+
+	if command == "index" {
+		info, err := file.Stat()
+		if err != nil {
+			fatalf("Error reading file %s: %s\n", filename, err)
+		}
+
+		indexer := newIndexWriter()
+
+		err = parseInput(p, *modeFlag, input, indexer)
+		checkReadError(err, filename)
+
+		indexer.index.setFileInfo(info, hex.EncodeToString(hash.Sum(nil)))
+		indexer.index.options = optionsFingerprint(flag.CommandLine, indexOptions)
+
+		if err := indexer.index.write(idxFilename); err != nil {
+			fatalf("Error writing index %s: %s\n", idxFilename, err)
+		}
+
+		log.Printf("Indexed %d sections in %s\n", indexer.ArticlesCount(), idxFilename)
+		return
+	}
+
+	if command == "lint" {
+		// Report missing titles instead of failing at the first one:
+		p.wikiFallback = firstWordFallback
+
+		l := newLinter(p)
+		l.checkDelimiters = *modeFlag == "delimiter"
+		l.checkWikiTitles = wikiMode && *modeFlag == "delimiter"
+		l.maxLines = *maxLinesFlag
+		l.maxBytes = *maxBytesFlag
+
+		err = parseInput(p, *modeFlag, io.TeeReader(input, l), l)
+		checkReadError(err, filename)
+		l.Finish()
+
+		name := filename
+		if name == "" {
+			name = "stdin"
+		}
+		l.Report(os.Stdout, name)
+
+		if l.ErrorsCount() > 0 {
+			fatalf("Error: %d of %d problems found are errors\n", l.ErrorsCount(), len(l.problems))
+		}
+		return
+	}
+
+	if manifestFS != nil && dryRunFS == nil {
+		lockFilename, err := lockOutputDir(outputDir)
+		if err != nil {
+			fatalf("Error: %s\n", err)
+		}
+
+		// the workers must be done before the lock is released:
+		atExit(func() {
+			if pool != nil {
+				pool.Wait()
+			}
+			unlockOutputDir(lockFilename)
+		})
+
+		if *cleanFlag {
+			count, err := cleanOutputDir(outputDir, dupesDir)
+			if err != nil {
+				fatalf("Error cleaning output directory %s: %s\n", outputDir, err)
+			}
+			log.Printf("Deleted %d files of the previous run\n", count)
+		} else if policy != emptyPolicy {
+			manifestFS.old, err = readManifest(manifestFilename)
+			if err != nil {
+				fatalf("Error reading manifest: %s\n", err)
+			}
+		}
+
+		prepareOutputDirs(outputDir, dupesDir, policy == emptyPolicy)
+	}
+
+	// the dry run checks the output directory and reads the manifest like
+	// the real run, but doesn't create or lock anything:
+	if manifestFS != nil && dryRunFS != nil {
+		checkOutputDir(outputDir, policy == emptyPolicy)
+
+		manifestFS.old, err = readManifest(manifestFilename)
+		if err != nil {
+			fatalf("Error reading manifest: %s\n", err)
+		}
+	}
+
+	sinks := multiWriter{writer}
+
+	var jsonSink *jsonWriter
+	if *jsonlFlag != "" {
+		out := openOutput(*jsonlFlag)
+		defer out.Close()
+		jsonSink = newJSONWriter(out)
+		sinks = append(sinks, jsonSink)
+	}
+
+	var csvSink *csvWriter
+	if *csvFlag != "" {
+		out := openOutput(*csvFlag)
+		defer out.Close()
+		separator := ','
+		if *csvTabFlag {
+			separator = '\t'
+		}
+		csvSink = newCSVWriter(out, separator, *csvContentFlag)
+		sinks = append(sinks, csvSink)
+	}
+
+	var prog *progress
+	if *progressFlag {
+		var total int64
+		if file != nil {
+			if info, err := file.Stat(); err == nil {
+				total = info.Size()
+			}
+		}
+
+		prog = newProgress(counter, total, os.Stderr, *progressIntervalFlag)
+		sinks = append(sinks, prog)
+		prog.Start()
+	}
+
+	err = parseInput(p, *modeFlag, input, sinks)
+	checkReadError(err, filename)
+
+	if prog != nil {
+		prog.Stop()
+	}
+
+	if csvSink != nil {
+		if err := csvSink.Flush(); err != nil {
+			fatalf("Error writing CSV to %s: %s\n", *csvFlag, err)
+		}
+	}
+
+	if jsonSink != nil {
+		if err := jsonSink.Flush(); err != nil {
+			fatalf("Error writing JSON Lines to %s: %s\n", *jsonlFlag, err)
+		}
+	}
+
+	if pool != nil {
+		if err := pool.Wait(); err != nil {
+			fatalf("Error: %s\n", err)
+		}
+	}
+
+	if manifestFS != nil {
+		if dryRunFS == nil {
+			if err := manifestFS.removeStale(*deleteFlag); err != nil {
+				fatalf("Error deleting files: %s\n", err)
+			}
+
+			if err := manifestFS.new.write(manifestFilename); err != nil {
+				fatalf("Error writing manifest %s: %s\n", manifestFilename, err)
+			}
 		} else {
-			log.Fatalf("Error reading from file %s: %s\n", filename, err)
+			// only counts the files that would be removed:
+			manifestFS.removeStale(false)
+		}
+
+		switch policy {
+		case updatePolicy:
+			removed := "removed"
+			if !*deleteFlag {
+				removed = "removed (kept, use -delete)"
+			}
+			log.Printf("Update: %d added, %d changed, %d %s, %d unchanged\n",
+				manifestFS.addedCount, manifestFS.changedCount, manifestFS.removedCount, removed, manifestFS.unchangedCount)
+		case noClobberPolicy:
+			log.Printf("Skipped %d existing files\n", manifestFS.skippedCount)
 		}
 	}
 
+	if dryRunFS != nil {
+		log.Printf("Dry run: %d new files, %d would be overwritten, %d collisions, %d skipped\n",
+			dryRunFS.newCount, dryRunFS.overwriteCount, dryRunFS.collisionCount, dryRunFS.skipCount)
+	}
+
 	if doStats {
-		printStats(writer)
+		var out io.Writer = os.Stderr
+		if *statsFileFlag != "" {
+			file := openOutput(*statsFileFlag)
+			defer file.Close()
+			out = file
+		} else if *statsFormatFlag != "text" {
+			out = os.Stdout
+		}
+
+		err := newStatistics(writer, filter, atomic.LoadInt64(&bytesWritten), time.Since(start)).write(out, *statsFormatFlag)
+		if err != nil {
+			fatalf("Error writing statistics: %s\n", err)
+		}
+
+		if *detailFlag {
+			writeDetail(out, writer, *topFlag)
+		}
+	}
+
+	if writer.FailedCount() > 0 {
+		fatalf("Error: command failed for %d files\n", writer.FailedCount())
 	}
 }
 
-func prepareOutputDirs(outputDir string, dupesDir string) {
-	err := os.MkdirAll(outputDir, os.ModePerm)
+// atExit registers a function to run when the program exits, like
+// releasing the lock of the output directory. The functions run in
+// reverse order.
+func atExit(f func()) {
+	cleanups = append(cleanups, f)
+}
+
+func runCleanups() {
+	for len(cleanups) > 0 {
+		f := cleanups[len(cleanups)-1]
+		cleanups = cleanups[:len(cleanups)-1]
+		f()
+	}
+}
+
+// fatal is like log.Fatal, but runs the cleanups first.
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	runCleanups()
+	os.Exit(1)
+}
+
+// fatalf is like log.Fatalf, but runs the cleanups first.
+func fatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	runCleanups()
+	os.Exit(1)
+}
+
+// parseCommand splits the subcommand, if any, from the arguments.
+func parseCommand(args []string) (string, []string) {
+	if len(args) > 0 {
+		for _, command := range commands {
+			if args[0] == command {
+				return command, args[1:]
+			}
+		}
+	}
+	return "split", args
+}
+
+// openIndex reads the index of filename. It returns nil if the input
+// has changed since the index was created, or if it was created with
+// different options.
+func openIndex(idxFilename string, filename string, options string, verify bool) *sectionIndex {
+	idx, err := readIndex(idxFilename)
 	if err != nil {
-		log.Fatalf("Error creating output directory %s: %s\n", outputDir, err)
+		fatalf("Error reading index %s: %s\n", idxFilename, err)
 	}
 
-	isEmpty, err := isDirEmpty(outputDir)
+	if idx.options != options {
+		log.Printf("Warning: index %s was created with different options, scanning %s\n", idxFilename, filename)
+		return nil
+	}
+
+	stale, err := idx.isStale(filename, verify)
 	if err != nil {
-		log.Fatalf("Error while checking if output directory %s is empty: %s\n", outputDir, err)
+		fatalf("Error checking index %s: %s\n", idxFilename, err)
 	}
-	if !isEmpty {
-		log.Fatalf("Error: Please make sure the output directory %s is empty\n", outputDir)
+
+	if stale {
+		log.Printf("Warning: index %s is stale, scanning %s\n", idxFilename, filename)
+		return nil
+	}
+
+	return idx
+}
+
+// getIndexed prints a single section using the index.
+func getIndexed(p *parser, file *os.File, idx *sectionIndex, title string, n int, out io.Writer) {
+	entry, ok := idx.lookup(title, n)
+	if !ok {
+		fatalf("Error: section %s (%d) not found\n", title, n)
+	}
+
+	// the index already reflects the filters:
+	p.filter = nil
+
+	getter := newSectionGetter("", 1, out)
+	err := p.parseFile(bufio.NewScanner(readSection(file, entry)), getter)
+	checkReadError(err, file.Name())
+
+	if !getter.Found() {
+		fatalf("Error: section %s (%d) not found\n", title, n)
+	}
+}
+
+// openOutput creates the named file, or returns stdout for "-".
+func openOutput(filename string) io.WriteCloser {
+	if filename == "-" {
+		return nopCloser{os.Stdout}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		fatalf("Error creating file %s: %s\n", filename, err)
+	}
+	return file
+}
+
+// nopCloser keeps stdout open when closing an output.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// parseInput hands the input to the parser according to the mode.
+func parseInput(p *parser, mode string, input io.Reader, w sectionWriter) error {
+	if mode == "xml" {
+		return p.parseXMLDump(input, w)
 	}
+	return p.parseFile(bufio.NewScanner(input), w)
+}
+
+func checkReadError(err error, filename string) {
+	if err == nil {
+		return
+	}
+
+	if _, ok := err.(*titleError); ok {
+		fatalf("Error: %s\n", err)
+	}
+
+	if filename == "" {
+		fatalf("Error reading from stdin: %s\n", err)
+	} else {
+		fatalf("Error reading from file %s: %s\n", filename, err)
+	}
+}
+
+// parseNamespaces parses a comma-separated list of namespace numbers.
+func parseNamespaces(list string) ([]int, error) {
+	var namespaces []int
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		ns, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+
+		namespaces = append(namespaces, ns)
+	}
+
+	return namespaces, nil
+}
+
+// isFlagSet checks if the flag with the given name has been specified on
+// the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func prepareOutputDirs(outputDir string, dupesDir string, requireEmpty bool) {
+	err := os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		fatalf("Error creating output directory %s: %s\n", outputDir, err)
+	}
+
+	checkOutputDir(outputDir, requireEmpty)
 
 	err = os.MkdirAll(dupesDir, os.ModePerm)
 	if err != nil {
-		log.Fatalf("Error creating duplicates directory %s: %s\n", dupesDir, err)
+		fatalf("Error creating duplicates directory %s: %s\n", dupesDir, err)
+	}
+}
+
+// checkOutputDir makes sure the output directory is empty, if required.
+// A missing output directory counts as empty.
+func checkOutputDir(outputDir string, requireEmpty bool) {
+	if !requireEmpty {
+		return
+	}
+
+	isEmpty, err := isDirEmpty(outputDir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fatalf("Error while checking if output directory %s is empty: %s\n", outputDir, err)
+	}
+	if !isEmpty {
+		fatalf("Error: Please make sure the output directory %s is empty (or use -clean, -force, -no-clobber or -update)\n", outputDir)
 	}
 }
 
@@ -102,23 +860,71 @@ func isDirEmpty(name string) (bool, error) {
 	}
 	defer file.Close()
 
-	_, err = file.Readdirnames(1)
+	names, err := file.Readdirnames(-1)
+	if err != nil {
+		return false, err
+	}
 
-	if err == io.EOF {
-		return true, nil
+	for _, name := range names {
+		if name != lockName {
+			return false, nil
+		}
 	}
-	return false, err
+	return true, nil
 }
 
-func printStats(w *fileWriter) {
-	var average int
-	if w.ArticlesCount() > 0 {
-		average = w.LinesCount() / w.ArticlesCount()
+// newFilter creates a filter from the command line options, or returns nil
+// if no filter options have been specified.
+func newFilter(include []string, exclude []string, grep string, titlesFrom string) (*sectionFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 && grep == "" && titlesFrom == "" {
+		return nil, nil
+	}
+
+	f := &sectionFilter{}
+
+	for _, pattern := range include {
+		m, err := newTitleMatcher(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid title pattern %s: %s", pattern, err)
+		}
+		f.include = append(f.include, m)
+	}
+
+	for _, pattern := range exclude {
+		m, err := newTitleMatcher(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid title pattern %s: %s", pattern, err)
+		}
+		f.exclude = append(f.exclude, m)
+	}
+
+	if grep != "" {
+		var err error
+		f.grep, err = regexp.Compile(grep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grep pattern %s: %s", grep, err)
+		}
+	}
+
+	if titlesFrom != "" {
+		var err error
+		f.titles, err = readTitles(titlesFrom)
+		if err != nil {
+			return nil, fmt.Errorf("reading titles from %s: %s", titlesFrom, err)
+		}
 	}
 
-	log.Printf("Number of files: %d\n", w.ArticlesCount())
-	log.Printf("Number of lines: %d\n", w.LinesCount())
-	log.Printf("Average numer of lines: %d\n", average)
-	log.Printf("Number of titles that appeared more than once: %d\n", w.DupeTitlesCount())
-	log.Printf("Number of duplicate files: %d\n", w.DupeFilesCount())
+	return f, nil
+}
+
+// stringList is a flag that can be specified multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
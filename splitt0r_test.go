@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"io/ioutil"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -431,6 +434,492 @@ func TestSplitWhitespaceAroundDelimiters(t *testing.T) {
 	}, 2, 5, 0, 0, fs, w)
 }
 
+func TestSplitIgnoresBOM(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"\ufefffoo foo",
+		"bar",
+		"=====",
+		"foo foo",
+		"baz",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo.txt":           "foo foo\nbar\n",
+		"output/dupes/foo (2).txt": "foo foo\nbaz\n",
+	}, 2, 4, 1, 1, fs, w)
+}
+
+func TestSplitMarkdown(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.markdownMode = true
+	p.headingLevel = 2
+	p.keepHeadings = true
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"# foo",
+		"",
+		"foo",
+		"=====",
+		"## bar ##",
+		"```sh",
+		"# baz",
+		"```",
+		"",
+		"### qux",
+		"bar",
+		"",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo.txt": "# foo\n\nfoo\n=====\n",
+		"output/bar.txt": "## bar ##\n```sh\n# baz\n```\n\n### qux\nbar\n",
+	}, 2, 11, 0, 0, fs, w)
+}
+
+func TestSplitMarkdownStripHeadings(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.markdownMode = true
+	p.headingLevel = 1
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"preamble",
+		"# foo",
+		"",
+		"foo",
+		"",
+		"# bar",
+		"# baz",
+		"## qux",
+		"~~~~",
+		"# quux",
+		"~~~",
+		"~~~~",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/preamble.txt": "preamble\n",
+		"output/foo.txt":      "foo\n",
+		"output/baz.txt":      "## qux\n~~~~\n# quux\n~~~\n~~~~\n",
+	}, 3, 7, 0, 0, fs, w)
+}
+
+func TestSplitHierarchical(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.levels = []rune{'-'}
+	p.indexName = "_index"
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"foo foo",
+		"intro",
+		"-----",
+		"bar bar",
+		"-----",
+		"baz baz",
+		"=====",
+		"qux qux",
+		"-----",
+		"bar bar",
+		"-----",
+		"bar bar",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo/_index.txt":        "foo foo\nintro\n",
+		"output/foo/bar.txt":           "bar bar\n",
+		"output/foo/baz.txt":           "baz baz\n",
+		"output/qux/_index.txt":        "qux qux\n",
+		"output/qux/bar.txt":           "bar bar\n",
+		"output/dupes/qux/bar (2).txt": "bar bar\n",
+	}, 6, 7, 1, 1, fs, w)
+}
+
+func TestSplitTitlesWithPathSeparators(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.levels = []rune{'-'}
+	p.indexName = ""
+	w := newFileWriter(fs, true, false, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"AC/DC",
+		"-----",
+		"../evil",
+		"-----",
+		"..",
+		"=====",
+		"..\\x",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/AC_DC/AC_DC.txt":   "AC/DC\n",
+		"output/AC_DC/.._evil.txt": "../evil\n",
+		"output/AC_DC/__.txt":      "..\n",
+		"output/.._x/.._x.txt":     "..\\x\n",
+	}, 4, 4, 0, 0, fs, w)
+}
+
+func TestIsInside(t *testing.T) {
+	testCases := []struct {
+		filename string
+		dir      string
+		expected bool
+	}{
+		{"output/foo.txt", "output", true},
+		{"output/a/b.txt", "output/", true},
+		{"output/../foo.txt", "output", false},
+		{"outputfoo.txt", "output", false},
+		{"foo.txt", ".", true},
+		{"../foo.txt", ".", false},
+		{"/tmp/out/foo.txt", "/tmp/out", true},
+	}
+	for _, tc := range testCases {
+		if actual := isInside(tc.filename, tc.dir); actual != tc.expected {
+			t.Errorf("isInside(%q, %q): expected %v, actual %v.", tc.filename, tc.dir, tc.expected, actual)
+		}
+	}
+}
+
+func TestSplitHierarchicalWithoutIndex(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.levels = []rune{'-', '~'}
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"=====",
+		"foo foo",
+		"-----",
+		"bar bar",
+		"~~~~~",
+		"baz baz",
+		"-----",
+		"",
+		"-----",
+		"qux qux",
+		"=====",
+		"quux quux",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo/foo.txt":     "foo foo\n",
+		"output/foo/bar/bar.txt": "bar bar\n",
+		"output/foo/bar/baz.txt": "baz baz\n",
+		"output/foo/qux/qux.txt": "qux qux\n",
+		"output/quux/quux.txt":   "quux quux\n",
+	}, 5, 5, 0, 0, fs, w)
+}
+
+func TestSplitMbox(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.mboxMode = true
+	p.mboxTitleField = "message-id"
+	w := newFileWriter(fs, true, true, "output", ".eml", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"From alice@example.com Sat Jan  3 01:05:34 2015",
+		"Message-ID: <1@example.com>",
+		"Subject: foo",
+		"",
+		"From here on, it's the body.",
+		">From quoted",
+		"=====",
+		"",
+		"From bob@example.com Sat Jan  3 01:05:34 2015",
+		"Subject: =?UTF-8?Q?caf=C3=A9?= and",
+		" tea/coffee",
+		"",
+		"bar",
+		"",
+		"From bob@example.com Sat Jan  3 01:05:34 2015",
+		"X-Subject: no title",
+		"",
+		"baz",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/1@example.com.eml":       "Message-ID: <1@example.com>\nSubject: foo\n\nFrom here on, it's the body.\nFrom quoted\n=====\n",
+		"output/café and tea_coffee.eml": "Subject: =?UTF-8?Q?caf=C3=A9?= and\n tea/coffee\n\nbar\n",
+		"output/message 3.eml":           "X-Subject: no title\n\nbaz\n",
+	}, 3, 13, 0, 0, fs, w)
+}
+
+func TestSplitMediaWikiXML(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.namespaces = []int{0}
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseXMLDump(strings.NewReader(`<mediawiki xml:lang="en">
+  <siteinfo><sitename>Wiki</sitename></siteinfo>
+  <page>
+    <title>Foo</title>
+    <ns>0</ns>
+    <revision><text xml:space="preserve">old</text></revision>
+    <revision><text xml:space="preserve">'''Foo''' is &lt;b&gt;foo&lt;/b&gt;.
+
+== Bar ==
+bar
+
+</text></revision>
+  </page>
+  <page>
+    <title>Template:Baz</title>
+    <ns>10</ns>
+    <revision><text xml:space="preserve">baz</text></revision>
+  </page>
+  <page>
+    <title>Foo</title>
+    <ns>0</ns>
+    <revision><text xml:space="preserve">foo again</text></revision>
+  </page>
+  <page>
+    <title>Foo/Bar</title>
+    <ns>0</ns>
+    <revision><text xml:space="preserve">subpage</text></revision>
+  </page>
+  <page>
+    <title>Empty</title>
+    <ns>0</ns>
+    <revision><text xml:space="preserve" /></revision>
+  </page>
+</mediawiki>`), w)
+
+	expect(t, map[string]string{
+		"output/Foo.txt":           "'''Foo''' is <b>foo</b>.\n\n== Bar ==\nbar\n",
+		"output/dupes/Foo (2).txt": "foo again\n",
+		"output/Foo_Bar.txt":       "subpage\n",
+	}, 3, 6, 1, 1, fs, w)
+}
+
+func TestSplitWikiOutputKeepRaw(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, true)
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+	w.wikiOutput = textOutput
+	w.rawExt = ".wiki"
+
+	p.parseFile(sl([]string{
+		"'''foo''' is [[Bar|bar]]",
+		"=====",
+		"'''foo''' again",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo.txt":            "foo is bar\n",
+		"output/foo.wiki":           "'''foo''' is [[Bar|bar]]\n",
+		"output/dupes/foo (2).txt":  "foo again\n",
+		"output/dupes/foo (2).wiki": "'''foo''' again\n",
+	}, 2, 2, 1, 1, fs, w)
+}
+
+func TestSplitExec(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+	w.command = `test "$SPLITT0R_TITLE" != bar || exit 1; tr a-z A-Z; echo "$SPLITT0R_TITLE $SPLITT0R_INDEX $SPLITT0R_DUPE"`
+
+	p.parseFile(sl([]string{
+		"foo foo",
+		"=====",
+		"bar bar",
+		"=====",
+		"foo foo",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo.txt":           "FOO FOO\nfoo 1 1\n",
+		"output/dupes/foo (2).txt": "FOO FOO\nfoo 3 2\n",
+	}, 3, 3, 1, 1, fs, w)
+
+	if w.FailedCount() != 1 {
+		t.Fatalf("Expected failed count 1, got: %d\n", w.FailedCount())
+	}
+}
+
+func TestSplitFilter(t *testing.T) {
+	include, _ := newTitleMatcher("^b")
+	exclude, _ := newTitleMatcher("glob:*x")
+
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.filter = &sectionFilter{
+		include: []titleMatcher{include},
+		exclude: []titleMatcher{exclude},
+		grep:    regexp.MustCompile("[0-9]"),
+		titles:  map[string]bool{"bar": true, "baz": true, "bax": true, "bay": true},
+	}
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"foo foo",
+		"123",
+		"=====",
+		"bar bar",
+		"456",
+		"=====",
+		"baz baz",
+		"no digits",
+		"=====",
+		"bax bax",
+		"789",
+		"=====",
+		"qux qux",
+		"012",
+		"=====",
+		"bay bay",
+		"345",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/bar.txt": "bar bar\n456\n",
+		"output/bay.txt": "bay bay\n345\n",
+	}, 2, 4, 0, 0, fs, w)
+
+	if p.filter.MatchedCount() != 2 || p.filter.SkippedCount() != 4 {
+		t.Fatalf("Expected 2 matched and 4 skipped, got: %d, %d\n", p.filter.MatchedCount(), p.filter.SkippedCount())
+	}
+}
+
+func TestGet(t *testing.T) {
+	b := &bytes.Buffer{}
+	p := newParser('=', 5, false)
+	g := newSectionGetter("foo", 2, b)
+
+	s := sl([]string{
+		"foo foo",
+		"123",
+		"=====",
+		"foo foo",
+		"456",
+		"",
+		"=====",
+		"bar bar",
+		"789",
+	})
+
+	p.parseFile(s, g)
+
+	if !g.Found() {
+		t.Fatalf("Expected section to be found")
+	}
+
+	if b.String() != "foo foo\n456\n" {
+		t.Fatalf("Expected second foo section, got: %q\n", b.String())
+	}
+
+	if !s.Scan() || s.Text() != "bar bar" {
+		t.Fatalf("Expected parser to stop reading after the section")
+	}
+}
+
+func TestSplitJSONLines(t *testing.T) {
+	fs := newMemoryFileSystem()
+	b := &bytes.Buffer{}
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, false, "output", ".txt", "output/dupes")
+	j := newJSONWriter(b)
+
+	p.parseFile(sl([]string{
+		"",
+		"foo \"foo\"",
+		"bar",
+		"",
+		"=====",
+		"foo foo",
+	}), multiWriter{w, j})
+
+	j.Flush()
+
+	expected := `{"title":"foo","dupe_index":1,"content":"foo \"foo\"\nbar","lines":2,"source_line":2}
+{"title":"foo","dupe_index":2,"content":"foo foo","lines":1,"source_line":6}
+`
+
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\n", expected, b.String())
+	}
+
+	expect(t, map[string]string{
+		"output/foo.txt":           "foo \"foo\"\nbar\n",
+		"output/dupes/foo (2).txt": "foo foo\n",
+	}, 2, 3, 1, 1, fs, w)
+}
+
+func TestSplitCSV(t *testing.T) {
+	testCases := []struct {
+		name      string
+		separator rune
+		content   bool
+		expected  string
+	}{
+		{"csv", ',', false, "title,dupe_index,lines,words,first_line\n" +
+			"foo,1,2,4,\"foo a, b\"\n" +
+			"foo,2,1,2,foo bar\n"},
+
+		{"tsv with content", '\t', true, "title\tdupe_index\tlines\twords\tfirst_line\tcontent\n" +
+			"foo\t1\t2\t4\tfoo a, b\t\"foo a, b\nbar\"\n" +
+			"foo\t2\t1\t2\tfoo bar\tfoo bar\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			p := newParser('=', 5, false)
+			w := newCSVWriter(b, tc.separator, tc.content)
+
+			p.parseFile(sl([]string{
+				"foo a, b",
+				"bar",
+				"",
+				"=====",
+				"foo bar",
+			}), w)
+
+			w.Flush()
+
+			if b.String() != tc.expected {
+				t.Fatalf("Expected:\n%s\nGot:\n%s\n", tc.expected, b.String())
+			}
+		})
+	}
+}
+
+func TestSplitDryRun(t *testing.T) {
+	b := &bytes.Buffer{}
+	fs := newDryRunFileSystem(charsetUTF16LE, b)
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, false, "splitt0r-nonexistent", ".txt", "splitt0r-nonexistent/dupes")
+
+	p.parseFile(sl([]string{
+		"foo a",
+		"=====",
+		"Foo",
+		"=====",
+		"foo",
+	}), w)
+
+	expected := "new               12  splitt0r-nonexistent/foo.txt\n" +
+		"collision          8  splitt0r-nonexistent/Foo.txt (with splitt0r-nonexistent/foo.txt)\n" +
+		"new                8  splitt0r-nonexistent/dupes/foo (2).txt\n"
+
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\n", expected, b.String())
+	}
+
+	if fs.newCount != 2 || fs.overwriteCount != 0 || fs.collisionCount != 1 {
+		t.Fatalf("Unexpected counts: %d new, %d overwrite, %d collisions", fs.newCount, fs.overwriteCount, fs.collisionCount)
+	}
+}
+
 // sl turns string slice into Scanner for testing convenience
 func sl(lines []string) *bufio.Scanner {
 	b := &bytes.Buffer{}
@@ -467,6 +956,15 @@ func expect(t *testing.T, expected map[string]string, expectedCount, expectedLin
 	}
 }
 
+// tempDir creates a temporary directory, which the caller must remove.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "splitt0r")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return dir
+}
+
 // memoryFileSystem provides a simulated file system for testing,
 // without actualyl writing files to disk
 type memoryFileSystem struct {
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+)
+
+// statistics summarizes a run, see printStats.
+type statistics struct {
+	Files          int            `json:"files"`
+	Lines          int            `json:"lines"`
+	AverageLines   int            `json:"average_lines"`
+	MinLines       int            `json:"min_lines"`
+	MaxLines       int            `json:"max_lines"`
+	MedianLines    float64        `json:"median_lines"`
+	EmptyLines     int            `json:"empty_lines"`
+	BytesWritten   int64          `json:"bytes_written"`
+	DupeTitles     int            `json:"dupe_titles"`
+	DupeFiles      int            `json:"dupe_files"`
+	MaxDupeFiles   int            `json:"max_dupe_files"`
+	Duplicates     map[string]int `json:"duplicates"` // number of sections per duplicate title
+	FilterMatched  *int           `json:"filter_matched,omitempty"`
+	FilterSkipped  *int           `json:"filter_skipped,omitempty"`
+	ElapsedSeconds float64        `json:"elapsed_seconds"`
+}
+
+// newStatistics summarizes the sections written by w. bytesWritten is
+// the size of the files actually written, after encoding.
+func newStatistics(w *fileWriter, f *sectionFilter, bytesWritten int64, elapsed time.Duration) *statistics {
+	s := &statistics{
+		Files:          w.ArticlesCount(),
+		Lines:          w.LinesCount(),
+		EmptyLines:     w.EmptyLinesCount(),
+		BytesWritten:   bytesWritten,
+		DupeTitles:     w.DupeTitlesCount(),
+		DupeFiles:      w.DupeFilesCount(),
+		Duplicates:     make(map[string]int),
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+
+	if s.Files > 0 {
+		s.AverageLines = s.Lines / s.Files
+
+		sizes := make([]int, len(w.sections))
+		for idx, section := range w.sections {
+			sizes[idx] = section.lines
+		}
+		sort.Ints(sizes)
+
+		s.MinLines = sizes[0]
+		s.MaxLines = sizes[len(sizes)-1]
+
+		if len(sizes)%2 == 1 {
+			s.MedianLines = float64(sizes[len(sizes)/2])
+		} else {
+			s.MedianLines = float64(sizes[len(sizes)/2-1]+sizes[len(sizes)/2]) / 2
+		}
+	}
+
+	for title, count := range w.titles {
+		if count > 1 {
+			s.Duplicates[title] = count
+		}
+		if count > 1 && count > s.MaxDupeFiles {
+			s.MaxDupeFiles = count
+		}
+	}
+
+	if f != nil {
+		matched, skipped := f.MatchedCount(), f.SkippedCount()
+		s.FilterMatched = &matched
+		s.FilterSkipped = &skipped
+	}
+
+	return s
+}
+
+// write prints the statistics in the given format: text (for humans),
+// json or prometheus (text exposition format).
+func (s *statistics) write(out io.Writer, format string) error {
+	switch format {
+	case "text":
+		s.writeText(out)
+		return nil
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "prometheus":
+		return s.writePrometheus(out)
+	}
+	return fmt.Errorf("unknown statistics format: %s", format)
+}
+
+func (s *statistics) writeText(out io.Writer) {
+	l := log.New(out, "", log.LstdFlags)
+
+	l.Printf("Number of files: %d\n", s.Files)
+	l.Printf("Number of lines: %d\n", s.Lines)
+	l.Printf("Average numer of lines: %d\n", s.AverageLines)
+	l.Printf("Number of titles that appeared more than once: %d\n", s.DupeTitles)
+	l.Printf("Number of duplicate files: %d\n", s.DupeFiles)
+	l.Printf("Minimum number of lines: %d\n", s.MinLines)
+	l.Printf("Maximum number of lines: %d\n", s.MaxLines)
+	l.Printf("Median number of lines: %g\n", s.MedianLines)
+	l.Printf("Number of empty lines: %d\n", s.EmptyLines)
+	l.Printf("Number of bytes written: %d\n", s.BytesWritten)
+
+	if s.FilterMatched != nil {
+		l.Printf("Number of sections matching filters: %d\n", *s.FilterMatched)
+		l.Printf("Number of sections skipped by filters: %d\n", *s.FilterSkipped)
+	}
+
+	l.Printf("Elapsed time: %.3fs\n", s.ElapsedSeconds)
+}
+
+// metric is a single Prometheus gauge.
+type metric struct {
+	name  string
+	help  string
+	value interface{}
+}
+
+func (s *statistics) writePrometheus(out io.Writer) error {
+	metrics := []metric{
+		{"files", "Number of files.", s.Files},
+		{"lines", "Number of lines.", s.Lines},
+		{"min_lines", "Minimum number of lines per file.", s.MinLines},
+		{"max_lines", "Maximum number of lines per file.", s.MaxLines},
+		{"median_lines", "Median number of lines per file.", s.MedianLines},
+		{"empty_lines", "Number of empty lines.", s.EmptyLines},
+		{"bytes_written", "Number of bytes written.", s.BytesWritten},
+		{"dupe_titles", "Number of titles that appeared more than once.", s.DupeTitles},
+		{"dupe_files", "Number of duplicate files.", s.DupeFiles},
+		{"max_dupe_files", "Maximum number of files with the same title.", s.MaxDupeFiles},
+		{"elapsed_seconds", "Elapsed time in seconds.", s.ElapsedSeconds},
+	}
+
+	if s.FilterMatched != nil {
+		metrics = append(metrics,
+			metric{"filter_matched", "Number of sections matching filters.", *s.FilterMatched},
+			metric{"filter_skipped", "Number of sections skipped by filters.", *s.FilterSkipped},
+		)
+	}
+
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(out, "# HELP splitt0r_%s %s\n# TYPE splitt0r_%s gauge\nsplitt0r_%s %v\n", m.name, m.help, m.name, m.name, m.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
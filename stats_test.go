@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatistics(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, true, false, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"foo foo",
+		"",
+		"bar",
+		"=====",
+		"foo foo",
+		"=====",
+		"bar \"bar\"",
+		"baz",
+		"=====",
+		"bar bar",
+	}), w)
+
+	var bytesWritten int64
+	for _, content := range fs.Files() {
+		bytesWritten += int64(len(content))
+	}
+
+	s := newStatistics(w, nil, bytesWritten, 0)
+
+	if s.Files != 4 || s.Lines != 7 || s.MinLines != 1 || s.MaxLines != 3 || s.MedianLines != 1.5 {
+		t.Fatalf("unexpected sizes: %+v", s)
+	}
+
+	if s.EmptyLines != 1 || s.BytesWritten != 43 {
+		t.Fatalf("unexpected empty lines or bytes: %+v", s)
+	}
+
+	if len(s.Duplicates) != 2 || s.Duplicates["foo"] != 2 || s.Duplicates["bar"] != 2 {
+		t.Fatalf("unexpected duplicates: %v", s.Duplicates)
+	}
+
+	b := &bytes.Buffer{}
+	if err := s.write(b, "prometheus"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expected := range []string{
+		"# TYPE splitt0r_files gauge\nsplitt0r_files 4\n",
+		"splitt0r_median_lines 1.5\n",
+		"splitt0r_max_dupe_files 2\n",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected prometheus output to contain %q, got:\n%s", expected, b.String())
+		}
+	}
+
+	if strings.Contains(b.String(), "splitt0r_duplicates") {
+		t.Errorf("expected no series per title in prometheus output, got:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := s.write(b, "json"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(b.String(), `"duplicates": {`) || strings.Contains(b.String(), "filter_matched") {
+		t.Errorf("unexpected json output:\n%s", b.String())
+	}
+
+	if err := s.write(b, "xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestDetail(t *testing.T) {
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	w := newFileWriter(fs, false, false, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"foo foo",
+		"",
+		"bar",
+		"=====",
+		"foo foo",
+		"=====",
+		"bar \"bar\"",
+		"baz",
+		"=====",
+		"bar bar",
+		"=====",
+		"baz",
+	}), w)
+
+	b := &bytes.Buffer{}
+	writeDetail(b, w, 1)
+
+	for _, expected := range []string{
+		"Lines per section:\n                      1        3 " + strings.Repeat("#", 50) + "\n                    2-3        2 " + strings.Repeat("#", 34) + "\n",
+		"  percentiles: p50=1 p90=3 p95=3 p99=3 p100=3\n",
+		"Largest sections:\n         3 lines         13 bytes  foo\n\n",
+		"Smallest sections:\n         1 lines          4 bytes  baz\n\n",
+		"Most duplicated titles:\n         2 times  bar\n",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected detail report to contain %q, got:\n%s", expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Transform modifies the lines of a section before they are handed to the
+// writer. Lines never contain trailing empty lines.
+type Transform interface {
+	Transform(title string, lines []string) []string
+}
+
+// TransformFunc turns an ordinary function into a Transform.
+type TransformFunc func(title string, lines []string) []string
+
+// Transform calls f(title, lines).
+func (f TransformFunc) Transform(title string, lines []string) []string {
+	return f(title, lines)
+}
+
+// transformChain applies several transforms one after another.
+type transformChain []Transform
+
+func (c transformChain) Transform(title string, lines []string) []string {
+	for _, t := range c {
+		lines = t.Transform(title, lines)
+	}
+	return lines
+}
+
+// transformConfig holds the settings of the built-in transforms.
+type transformConfig struct {
+	wrapWidth int
+	header    string
+}
+
+// TransformFactory creates a transform using the given settings.
+type TransformFactory func(cfg transformConfig) Transform
+
+var transforms = map[string]TransformFactory{
+	"trim-trailing": func(cfg transformConfig) Transform {
+		return TransformFunc(trimTrailing)
+	},
+	"collapse-blank": func(cfg transformConfig) Transform {
+		return TransformFunc(collapseBlank)
+	},
+	"wrap": func(cfg transformConfig) Transform {
+		return TransformFunc(func(title string, lines []string) []string {
+			return wrapLines(lines, cfg.wrapWidth)
+		})
+	},
+	"header": func(cfg transformConfig) Transform {
+		return TransformFunc(func(title string, lines []string) []string {
+			header := strings.Replace(cfg.header, "{title}", title, -1)
+			return append(strings.Split(header, "\n"), lines...)
+		})
+	},
+}
+
+// RegisterTransform makes a custom transform available by name, so it can
+// be selected using -transform. It panics if the name is already taken.
+func RegisterTransform(name string, factory TransformFactory) {
+	if _, exists := transforms[name]; exists {
+		panic("Transform already registered: " + name)
+	}
+	transforms[name] = factory
+}
+
+// transformNames returns the names of all registered transforms.
+func transformNames() []string {
+	var names []string
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTransformChain creates a chain of the named transforms.
+func newTransformChain(names []string, cfg transformConfig) (transformChain, error) {
+	var chain transformChain
+
+	for _, name := range names {
+		factory, ok := transforms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform: %s", name)
+		}
+		chain = append(chain, factory(cfg))
+	}
+
+	return chain, nil
+}
+
+// trimTrailing removes whitespace at the end of each line.
+func trimTrailing(title string, lines []string) []string {
+	result := make([]string, len(lines))
+	for idx, line := range lines {
+		result[idx] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return result
+}
+
+// collapseBlank replaces runs of empty lines by a single empty line.
+func collapseBlank(title string, lines []string) []string {
+	result := make([]string, 0, len(lines))
+	for idx, line := range lines {
+		if idx > 0 && isBlank(line) && isBlank(lines[idx-1]) {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// wrapLines breaks lines longer than width at spaces. Leading whitespace
+// is repeated on continuation lines. Words longer than width are not
+// broken.
+func wrapLines(lines []string, width int) []string {
+	if width <= 0 {
+		return lines
+	}
+
+	result := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		if utf8.RuneCountInString(line) <= width {
+			result = append(result, line)
+			continue
+		}
+
+		indent := line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+		current := indent
+
+		for _, word := range strings.Fields(line) {
+			if current != indent && utf8.RuneCountInString(current)+1+utf8.RuneCountInString(word) > width {
+				result = append(result, current)
+				current = indent
+			}
+			if current != indent {
+				current += " "
+			}
+			current += word
+		}
+
+		result = append(result, current)
+	}
+
+	return result
+}
+
+func isBlank(line string) bool {
+	return len(strings.TrimSpace(line)) == 0
+}
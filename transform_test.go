@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	testCases := []struct {
+		names    []string
+		input    []string
+		expected []string
+	}{
+		{[]string{"trim-trailing"}, []string{"foo  ", "\tbar\t"}, []string{"foo", "\tbar"}},
+
+		{[]string{"collapse-blank"}, []string{"foo", "", " ", "", "bar", "", "baz"}, []string{"foo", "", "bar", "", "baz"}},
+
+		{[]string{"wrap"}, []string{"foo bar baz qux", "  foo bar baz", "foobarbazqux"}, []string{"foo bar", "baz qux", "  foo", "  bar", "  baz", "foobarbazqux"}},
+
+		{[]string{"header"}, []string{"foo"}, []string{"# title", "", "foo"}},
+
+		{[]string{"trim-trailing", "collapse-blank"}, []string{"foo", " ", "\t", "bar "}, []string{"foo", "", "bar"}},
+	}
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.names, ","), func(t *testing.T) {
+			chain, err := newTransformChain(tc.names, transformConfig{wrapWidth: 7, header: "# {title}\n"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual := chain.Transform("title", tc.input)
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected: %q, actual: %q.", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTransformUnknown(t *testing.T) {
+	_, err := newTransformChain([]string{"foo"}, transformConfig{})
+	if err == nil {
+		t.Fatalf("expected error for unknown transform")
+	}
+}
+
+func TestSplitCustomTransform(t *testing.T) {
+	RegisterTransform("upper", func(cfg transformConfig) Transform {
+		return TransformFunc(func(title string, lines []string) []string {
+			result := make([]string, len(lines))
+			for idx, line := range lines {
+				result[idx] = strings.ToUpper(line)
+			}
+			return result
+		})
+	})
+	defer delete(transforms, "upper")
+
+	chain, err := newTransformChain([]string{"upper"}, transformConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fs := newMemoryFileSystem()
+	p := newParser('=', 5, false)
+	p.transform = chain
+	w := newFileWriter(fs, true, true, "output", ".txt", "output/dupes")
+
+	p.parseFile(sl([]string{
+		"foo foo",
+		"bar",
+		"",
+		"=====",
+		"baz baz",
+	}), w)
+
+	expect(t, map[string]string{
+		"output/foo.txt": "FOO FOO\nBAR\n",
+		"output/baz.txt": "BAZ BAZ\n",
+	}, 2, 3, 0, 0, fs, w)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+type wikiMarkup int
+
+const (
+	none = iota
+	italic
+	bold
+	boldItalic
+)
+
+type wikiFallback int
+
+const (
+	failFallback = iota
+	firstWordFallback
+)
+
+// Kinds of MediaWiki titles, see parseWikiTitle.
+const (
+	markupTitle   = "markup"
+	headingTitle  = "heading"
+	templateTitle = "template"
+	linkTitle     = "link"
+)
+
+var defaultWikiOrder = []string{markupTitle, headingTitle, templateTitle, linkTitle}
+
+var (
+	rBoldItalic = regexp.MustCompile("''''(.+?)''''")
+	rBold       = regexp.MustCompile("'''(.+?)'''")
+	rItalic     = regexp.MustCompile("''(.+?)''")
+	rQuotes     = regexp.MustCompile("''+")
+	rHeading    = regexp.MustCompile(`^(={1,6})\s*([^=].*?)\s*(={1,6})\s*$`)
+	rLink       = regexp.MustCompile(`\[\[([^\[\]|]*)(?:\|([^\[\]]*))?\]\]`)
+	rTemplate   = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+)
+
+// isWikiTitleKind checks if kind can be used in the title precedence
+// order.
+func isWikiTitleKind(kind string) bool {
+	for _, k := range defaultWikiOrder {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWikiTitle looks for a title in the given kinds of MediaWiki markup,
+// in order of precedence:
+//   - markup: the first italic, bold or bold-italic text
+//   - heading: a "== Heading ==" line
+//   - template: a parameter like "{{Infobox|title=Title}}"
+//   - link: the first "[[Target|Label]]" link
+//
+// It returns an empty string if no title is found.
+func (p *parser) parseWikiTitle(line string) string {
+	order := p.wikiOrder
+	if len(order) == 0 {
+		order = defaultWikiOrder
+	}
+
+	for _, kind := range order {
+		var title string
+
+		switch kind {
+		case markupTitle:
+			title = findWikiMarkup(line)
+		case headingTitle:
+			title = findWikiHeading(line)
+		case templateTitle:
+			title = p.findWikiTemplateParam(line)
+		case linkTitle:
+			title = findWikiLink(line)
+		}
+
+		if title = p.cleanWikiTitle(title); title != "" {
+			return title
+		}
+	}
+
+	return ""
+}
+
+// findWikiMarkup returns the first text formatted either italic, bold or
+// bold-italic, whichever comes first.
+func findWikiMarkup(line string) string {
+	idxBoldItalic := rBoldItalic.FindStringIndex(line)
+	idxBold := rBold.FindStringIndex(line)
+	idxItalic := rItalic.FindStringIndex(line)
+
+	idxMin := -1
+	var m wikiMarkup
+
+	if idxBoldItalic != nil {
+		idxMin = idxBoldItalic[0]
+		m = boldItalic
+	}
+	if idxBold != nil && (idxBold[0] < idxMin || idxMin == -1) {
+		idxMin = idxBold[0]
+		m = bold
+	}
+	if idxItalic != nil && (idxItalic[0] < idxMin || idxMin == -1) {
+		m = italic
+	}
+
+	switch m {
+	case boldItalic:
+		return rBoldItalic.FindStringSubmatch(line)[1]
+	case bold:
+		return rBold.FindStringSubmatch(line)[1]
+	case italic:
+		return rItalic.FindStringSubmatch(line)[1]
+	}
+
+	return ""
+}
+
+// findWikiLink returns the first link, ignoring links to files and
+// categories.
+func findWikiLink(line string) string {
+	for _, m := range rLink.FindAllStringSubmatch(line, -1) {
+		target := strings.ToLower(strings.TrimSpace(m[1]))
+		if strings.HasPrefix(target, "file:") || strings.HasPrefix(target, "image:") || strings.HasPrefix(target, "category:") {
+			continue
+		}
+		return m[0]
+	}
+	return ""
+}
+
+func findWikiHeading(line string) string {
+	m := rHeading.FindStringSubmatch(line)
+	if m == nil || len(m[1]) != len(m[3]) {
+		return ""
+	}
+	return m[2]
+}
+
+func (p *parser) findWikiTemplateParam(line string) string {
+	name := p.wikiTemplateParam
+	if name == "" {
+		name = "title"
+	}
+
+	for _, template := range rTemplate.FindAllString(line, -1) {
+		// links may contain pipes, too:
+		template = rLink.ReplaceAllStringFunc(template, func(link string) string {
+			return strings.Replace(link, "|", "\x00", -1)
+		})
+
+		params := strings.Split(strings.TrimSuffix(template, "}}"), "|")
+
+		for _, param := range params[1:] {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == name {
+				return strings.Replace(kv[1], "\x00", "|", -1)
+			}
+		}
+	}
+
+	return ""
+}
+
+// cleanWikiTitle resolves links and removes quote markup, so nested markup
+// like a bold link results in the plain link target (or label).
+func (p *parser) cleanWikiTitle(title string) string {
+	title = rLink.ReplaceAllStringFunc(title, func(link string) string {
+		m := rLink.FindStringSubmatch(link)
+		target, label := m[1], m[2]
+
+		if idx := strings.Index(target, "#"); idx >= 0 {
+			target = target[:idx]
+		}
+		target = strings.TrimPrefix(strings.TrimSpace(target), ":")
+
+		if (p.wikiLinkLabel && label != "") || target == "" {
+			return label
+		}
+		return target
+	})
+
+	title = rQuotes.ReplaceAllString(title, "")
+
+	return strings.TrimSpace(title)
+}
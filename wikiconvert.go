@@ -0,0 +1,113 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+type wikiOutput int
+
+const (
+	rawOutput = iota
+	textOutput
+	markdownOutput
+)
+
+var (
+	rComment      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	rRef          = regexp.MustCompile(`(?s)<ref[^>]*/>|<ref[^>]*>.*?</ref>`)
+	rExternalLink = regexp.MustCompile(`\[((?:https?:)?//[^\s\]]+)(?:\s+([^\]]*))?\]`)
+	rHeadingLine  = regexp.MustCompile(`(?m)^(={1,6})[ \t]*([^=\n].*?)[ \t]*={1,6}[ \t]*$`)
+	rListItem     = regexp.MustCompile(`(?m)^([*#]+)[ \t]*`)
+	rFiveQuotes   = regexp.MustCompile(`'''''(.+?)'''''`)
+)
+
+// convertWikiMarkup converts the MediaWiki markup in the lines of a section
+// to plain text or Markdown. Templates, references, comments and links to
+// files and categories are removed.
+func convertWikiMarkup(lines []string, format wikiOutput) []string {
+	text := strings.Join(lines, "\n")
+
+	text = rComment.ReplaceAllString(text, "")
+	text = rRef.ReplaceAllString(text, "")
+	text = replaceAllRepeated(rTemplate, text, func(string) string { return "" })
+
+	text = replaceAllRepeated(rLink, text, func(link string) string {
+		m := rLink.FindStringSubmatch(link)
+		target, label := strings.TrimSpace(m[1]), m[2]
+
+		lower := strings.ToLower(target)
+		if strings.HasPrefix(lower, "file:") || strings.HasPrefix(lower, "image:") || strings.HasPrefix(lower, "category:") {
+			return ""
+		}
+
+		if label != "" {
+			return label
+		}
+		return strings.TrimPrefix(target, ":")
+	})
+
+	text = rExternalLink.ReplaceAllStringFunc(text, func(link string) string {
+		m := rExternalLink.FindStringSubmatch(link)
+		url, label := m[1], strings.TrimSpace(m[2])
+
+		if format == markdownOutput {
+			if label == "" {
+				return "<" + url + ">"
+			}
+			return "[" + label + "](" + url + ")"
+		}
+
+		if label == "" {
+			return url
+		}
+		return label
+	})
+
+	if format == markdownOutput {
+		text = rListItem.ReplaceAllStringFunc(text, func(item string) string {
+			marks := strings.TrimSpace(item)
+			indent := strings.Repeat("  ", len(marks)-1)
+			if marks[len(marks)-1] == '#' {
+				return indent + "1. "
+			}
+			return indent + "- "
+		})
+
+		text = rHeadingLine.ReplaceAllStringFunc(text, func(line string) string {
+			m := rHeadingLine.FindStringSubmatch(line)
+			return strings.Repeat("#", len(m[1])) + " " + m[2]
+		})
+
+		text = rFiveQuotes.ReplaceAllString(text, "***$1***")
+		text = rBoldItalic.ReplaceAllString(text, "***$1***")
+		text = rBold.ReplaceAllString(text, "**$1**")
+		text = rItalic.ReplaceAllString(text, "*$1*")
+	} else {
+		text = rHeadingLine.ReplaceAllString(text, "$2")
+		text = rQuotes.ReplaceAllString(text, "")
+	}
+
+	lines = strings.Split(text, "\n")
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// replaceAllRepeated replaces matches until there are none left, which
+// resolves nested constructs from the inside out.
+func replaceAllRepeated(r *regexp.Regexp, text string, repl func(string) string) string {
+	for {
+		replaced := r.ReplaceAllStringFunc(text, repl)
+		if replaced == text {
+			return text
+		}
+		text = replaced
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertWikiMarkup(t *testing.T) {
+	input := []string{
+		"{{Infobox",
+		"| title = Foo",
+		"}}",
+		"'''Foo''' is a ''[[Bar|bar]]''<ref>Baz, p. 1</ref> from [[Qux]].<!-- TODO -->",
+		"[[File:Foo.png|thumb|A [[Bar]]]]",
+		"== History ==",
+		"* See [https://example.com example]",
+		"** and [https://example.org]",
+		"# first",
+		"[[Category:Foo]]",
+	}
+
+	testCases := []struct {
+		name     string
+		format   wikiOutput
+		expected []string
+	}{
+		{"text", textOutput, []string{
+			"Foo is a bar from Qux.",
+			"",
+			"History",
+			"* See example",
+			"** and https://example.org",
+			"# first",
+		}},
+
+		{"markdown", markdownOutput, []string{
+			"**Foo** is a *bar* from Qux.",
+			"",
+			"## History",
+			"- See [example](https://example.com)",
+			"  - and <https://example.org>",
+			"1. first",
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := convertWikiMarkup(input, tc.format)
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected:\n%q\nactual:\n%q", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -4,8 +4,16 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"strings"
 )
 
+// sectionSize records the size of a section for statistics.
+type sectionSize struct {
+	title string
+	lines int
+	bytes int
+}
+
 type fileWriter struct {
 	fileSystem fileSystem
 
@@ -15,10 +23,18 @@ type fileWriter struct {
 	outputExt string
 	dupesDir  string
 
+	wikiOutput wikiOutput // convert MediaWiki markup in output files
+	rawExt     string     // if set, also write unconverted files
+	command    string     // if set, pipe content through this shell command
+
 	articlesCount   int
 	linesCount      int
 	dupeTitlesCount int
 	dupeFilesCount  int
+	failedCount     int
+	emptyLinesCount int
+
+	sections []sectionSize
 
 	titles map[string]int
 }
@@ -56,31 +72,37 @@ func (w *fileWriter) WriteFile(title string, content []string, emptyLines int) {
 	lines := len(content) - emptyLines
 
 	w.linesCount += lines
+	size := sectionSize{title: title, lines: lines}
 
-	if w.doWrite {
-		var filename string
-		if count == 1 {
-			filename = path.Join(w.outputDir, title+w.outputExt)
-		} else {
-			filename = path.Join(w.dupesDir, fmt.Sprintf("%s (%d)%s", title, count, w.outputExt))
+	for _, line := range content[:lines] {
+		size.bytes += len(line) + 1
+		if isBlank(line) {
+			w.emptyLinesCount++
 		}
+	}
 
-		err := w.fileSystem.WriteOpen(filename)
+	w.sections = append(w.sections, size)
 
-		if err != nil {
-			log.Fatalf("Error opening file %s for writing: %s\n", filename, err)
-		}
+	if w.doWrite {
+		content = content[:lines]
 
-		for idx, line := range content {
-			if idx == lines {
-				break
+		if w.wikiOutput != rawOutput {
+			if w.rawExt != "" {
+				w.writeLines(w.filename(title, count, w.rawExt), content)
 			}
-			w.fileSystem.Fprintln(line)
+			content = convertWikiMarkup(content, w.wikiOutput)
+		}
+
+		var err error
+		if w.command != "" {
+			content, err = runCommand(w.command, content, title, w.articlesCount, count)
 		}
 
-		err = w.fileSystem.FlushClose()
 		if err != nil {
-			log.Fatalf("Error writing to file %s: %s\n", filename, err)
+			w.failedCount++
+			log.Printf("Error running command for %s: %s\n", title, err)
+		} else {
+			w.writeLines(w.filename(title, count, w.outputExt), content)
 		}
 	}
 
@@ -89,6 +111,48 @@ func (w *fileWriter) WriteFile(title string, content []string, emptyLines int) {
 	}
 }
 
+// filename returns the path of the output file for the count-th section
+// with the given title.
+func (w *fileWriter) filename(title string, count int, ext string) string {
+	if count == 1 {
+		return path.Join(w.outputDir, title+ext)
+	}
+	return path.Join(w.dupesDir, fmt.Sprintf("%s (%d)%s", title, count, ext))
+}
+
+func (w *fileWriter) writeLines(filename string, lines []string) {
+	if !isInside(filename, w.outputDir) {
+		fatalf("Error: file %s is outside of the output directory %s\n", filename, w.outputDir)
+	}
+
+	err := w.fileSystem.WriteOpen(filename)
+
+	if err != nil {
+		fatalf("Error opening file %s for writing: %s\n", filename, err)
+	}
+
+	for _, line := range lines {
+		w.fileSystem.Fprintln(line)
+	}
+
+	err = w.fileSystem.FlushClose()
+	if err != nil {
+		fatalf("Error writing to file %s: %s\n", filename, err)
+	}
+}
+
+// isInside checks if filename is located in dir or its subdirectories.
+func isInside(filename string, dir string) bool {
+	filename = path.Clean(filename)
+	dir = path.Clean(dir)
+
+	if dir == "." {
+		return !path.IsAbs(filename) && filename != ".." && !strings.HasPrefix(filename, "../")
+	}
+
+	return strings.HasPrefix(filename, strings.TrimSuffix(dir, "/")+"/")
+}
+
 func (w *fileWriter) ArticlesCount() int {
 	return w.articlesCount
 }
@@ -104,3 +168,45 @@ func (w *fileWriter) DupeTitlesCount() int {
 func (w *fileWriter) DupeFilesCount() int {
 	return w.dupeFilesCount
 }
+
+func (w *fileWriter) EmptyLinesCount() int {
+	return w.emptyLinesCount
+}
+
+func (w *fileWriter) Done() bool {
+	return false
+}
+
+func (w *fileWriter) FailedCount() int {
+	return w.failedCount
+}
+
+// multiWriter hands each section to several writers.
+type multiWriter []sectionWriter
+
+func (m multiWriter) SetLocation(loc sectionLocation) {
+	for _, w := range m {
+		if lw, ok := w.(locationWriter); ok {
+			lw.SetLocation(loc)
+		}
+	}
+}
+
+func (m multiWriter) WriteFile(title string, content []string, emptyLines int) {
+	for _, w := range m {
+		w.WriteFile(title, content, emptyLines)
+	}
+}
+
+func (m multiWriter) ArticlesCount() int {
+	return m[0].ArticlesCount()
+}
+
+func (m multiWriter) Done() bool {
+	for _, w := range m {
+		if !w.Done() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// xmlPage is a page of a MediaWiki XML export, see
+// https://www.mediawiki.org/wiki/Help:Export
+type xmlPage struct {
+	Title     string `xml:"title"`
+	NS        int    `xml:"ns"`
+	Revisions []struct {
+		Text string `xml:"text"`
+	} `xml:"revision"`
+}
+
+// parseXMLDump streams a MediaWiki XML export and writes the text of the
+// latest revision of each page, using the page title as title.
+func (p *parser) parseXMLDump(r io.Reader, w sectionWriter) error {
+	p.writer = w
+
+	decoder := xml.NewDecoder(r)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+
+		var page xmlPage
+		if err := decoder.DecodeElement(&page, &start); err != nil {
+			return err
+		}
+
+		p.writePage(page)
+
+		if p.writer.Done() {
+			return nil
+		}
+	}
+}
+
+func (p *parser) writePage(page xmlPage) {
+	if !p.includeNamespace(page.NS) || len(page.Revisions) == 0 {
+		return
+	}
+
+	title := page.Title
+	if p.stripInvisible {
+		title = removeInvisible(title)
+	}
+
+	lines := strings.Split(page.Revisions[len(page.Revisions)-1].Text, "\n")
+
+	// remove empty lines around the content, just like in the input
+	// file modes:
+	for len(lines) > 0 && p.isEmpty(lines[0]) {
+		lines = lines[1:]
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	emptyLines := 0
+	for p.isEmpty(lines[len(lines)-1-emptyLines]) {
+		emptyLines++
+	}
+
+	if p.filter != nil && !p.filter.match(title, lines[:len(lines)-emptyLines]) {
+		return
+	}
+
+	lines, emptyLines = p.transformLines(title, lines, emptyLines)
+
+	// the location in the XML file is unknown:
+	if lw, ok := p.writer.(locationWriter); ok {
+		lw.SetLocation(sectionLocation{title: title})
+	}
+
+	// subpages like Foo/Bar don't create directories:
+	p.writer.WriteFile(sanitizeTitle(title), lines, emptyLines)
+}
+
+func (p *parser) includeNamespace(ns int) bool {
+	if len(p.namespaces) == 0 {
+		return true
+	}
+
+	for _, n := range p.namespaces {
+		if n == ns {
+			return true
+		}
+	}
+
+	return false
+}